@@ -1,12 +1,17 @@
 package nit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v59/github"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -14,6 +19,8 @@ const (
 	modelGood  = "slow"
 	formatJSON = "json"
 	formatText = "text"
+	// The completion returned when the changes match the pull request description
+	noissues = "noissues"
 )
 
 //go:generate moq -out mock_AIProvider_test.go . AIProvider
@@ -24,9 +31,122 @@ type AIProvider interface {
 	CreateCompletetion(req *CompletionRequest) (*CompletionResponse, error)
 }
 
+// Whether (and with which model) generated review comments are critiqued before posting.
+const (
+	CritiqueOff   = "off"
+	CritiqueCheap = "cheap"
+	CritiqueGood  = "good"
+)
+
+// How a generated pull request description is published.
+const (
+	// Descriptions are not generated
+	DescribeOff = "off"
+	// Descriptions are posted as a comment on the pull request
+	DescribeComment = "comment"
+	// Descriptions are written to the pull request body
+	DescribeUpdate = "update"
+)
+
 type Config struct {
 	OptIn   bool
 	AppName string
+	// The most pages of results retrieved from GitHub list endpoints, defaults to
+	// defaultMaxPages when it isn't set
+	MaxPages int
+	// The ID of the GitHub user the app acts as, used to ignore its own comments
+	UserID int64
+	// The default settings for every repository
+	RepoConfig
+	// Settings for specific repositories keyed by "owner/repo". Settings that aren't set
+	// fall back to the defaults.
+	Repos map[string]RepoConfig
+	// Where dry runs are written, they are logged when it is nil
+	DryRunWriter DryRunWriter
+}
+
+// Settings that can be configured per repository.
+type RepoConfig struct {
+	// How generated pull request descriptions are published (DescribeOff, DescribeComment
+	// or DescribeUpdate)
+	Describe string
+	// The lowest severity of review comments that are posted inline (SeverityNit,
+	// SeverityMinor, SeverityMajor or SeverityBlocker). Defaults to SeverityNit.
+	InlineSeverity string
+	// The lowest severity of review comments that are summarized in the review body when
+	// they aren't posted inline, comments below this are dropped. Defaults to SeverityNit.
+	SummarySeverity string
+	// Whether each review comment is checked by a model before posting (CritiqueOff,
+	// CritiqueCheap or CritiqueGood). Defaults to CritiqueOff.
+	Critique string
+	// Which events reviews can be posted with (ReviewPolicyApprove, ReviewPolicyNeverApprove,
+	// ReviewPolicyComment or ReviewPolicyRequestChanges). Defaults to ReviewPolicyApprove.
+	ReviewPolicy string
+	// Whether anything is written to pull requests or it is only generated (DryRunOff or
+	// DryRunOn). Defaults to DryRunOff.
+	DryRun string
+}
+
+// Get the settings for a repository, falling back to the defaults for anything that
+// isn't configured specifically for it.
+func (c *Config) ForRepo(owner, repo string) RepoConfig {
+	result := c.RepoConfig
+	override, ok := c.Repos[strings.ToLower(owner+"/"+repo)]
+	if !ok {
+		return result
+	}
+
+	if override.Describe != "" {
+		result.Describe = override.Describe
+	}
+	if override.InlineSeverity != "" {
+		result.InlineSeverity = override.InlineSeverity
+	}
+	if override.SummarySeverity != "" {
+		result.SummarySeverity = override.SummarySeverity
+	}
+	if override.Critique != "" {
+		result.Critique = override.Critique
+	}
+	if override.ReviewPolicy != "" {
+		result.ReviewPolicy = override.ReviewPolicy
+	}
+	if override.DryRun != "" {
+		result.DryRun = override.DryRun
+	}
+	return result
+}
+
+// Check that the settings, including those of each repository, are known values. Empty
+// settings are valid since they fall back to the defaults.
+func (c *Config) Validate() error {
+	if err := c.RepoConfig.validate(); err != nil {
+		return err
+	}
+	for name, repo := range c.Repos {
+		if err := repo.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (c RepoConfig) validate() error {
+	check := func(setting, value string, valid ...string) error {
+		if value == "" || slices.Contains(valid, value) {
+			return nil
+		}
+		return fmt.Errorf("unknown %s %q", setting, value)
+	}
+
+	return errors.Join(
+		check("describe", c.Describe, DescribeOff, DescribeComment, DescribeUpdate),
+		check("inline severity", c.InlineSeverity, severities...),
+		check("summary severity", c.SummarySeverity, severities...),
+		check("critique", c.Critique, CritiqueOff, CritiqueCheap, CritiqueGood),
+		check("review policy", c.ReviewPolicy, ReviewPolicyApprove, ReviewPolicyNeverApprove, ReviewPolicyComment, ReviewPolicyRequestChanges),
+		check("dry run", c.DryRun, DryRunOff, DryRunOn),
+	)
 }
 
 type CompletionRequest struct {
@@ -38,11 +158,52 @@ type CompletionRequest struct {
 type CompletionResponse struct {
 	Completion string
 	Tokens     int
+	// The name of the model that created the completion, if the provider reports it
+	Model string
+}
+
+// The details of a pull request that are given to the AI when generating a review.
+type PullRequestDetails struct {
+	Number      int
+	Title       string
+	Description string
+	// The issues linked from the pull request description (ie. "fixes #123")
+	Issues []*github.Issue
+	// The messages of the commits in the pull request
+	Commits []string
+	// The head versions of the changed files keyed by path. Suggested changes can only
+	// be made to these files.
+	Files map[string]string
+	// The review comments already on the pull request. Generated comments that repeat
+	// them are dropped.
+	ExistingComments []*github.PullRequestComment
+}
+
+// Numbers about a generated pull request review.
+type ReviewStats struct {
+	// The total tokens used to generate the review
+	Tokens int
+	// The model that generated the review comments
+	Model string
+	// The number of comments checked by the self-critique pass
+	Critiqued int
+	// The number of comments dropped by the self-critique pass
+	Dropped int
+	// The number of comments dropped as duplicates of existing or other generated comments
+	Duplicates int
+	// The number of comments below the inline severity, summarized in the review body or dropped
+	BelowSeverity int
+	// The number of comments that are blocking issues
+	Blockers int
 }
 
 type AI struct {
 	Cheap AIProvider
 	Good  AIProvider
+	// Where events are logged, set with WithLogger
+	logger *slog.Logger
+	// Whether prompts and completions are left out of the logs
+	RedactContent bool
 }
 
 type completion struct {
@@ -74,7 +235,7 @@ func (c *completion) ReturnText() *completion {
 	return c
 }
 
-func (c *completion) Create(prompt string) (*CompletionResponse, error) {
+func (c *completion) Create(ctx context.Context, prompt string) (*CompletionResponse, error) {
 	if prompt == "" {
 		return &CompletionResponse{}, errors.New("the prompt is empty. aborting completion")
 	}
@@ -84,7 +245,31 @@ func (c *completion) Create(prompt string) (*CompletionResponse, error) {
 		Prompt: prompt,
 		Format: c.format,
 	}
-	return c.provider.CreateCompletetion(&req)
+
+	_, span := startSpan(ctx, "completion",
+		attribute.String("nit.provider", providerName(c.provider)),
+		attribute.String("nit.tier", c.tier()),
+		attribute.String("nit.format", c.format),
+	)
+	start := time.Now()
+	resp, err := c.provider.CreateCompletetion(&req)
+	elapsed := time.Since(start)
+	if resp != nil {
+		span.SetAttributes(attribute.String("nit.model", resp.Model), attribute.Int("nit.tokens", resp.Tokens))
+	}
+	endSpan(span, err)
+
+	recordCompletion(c.provider, c.tier(), resp, elapsed)
+	c.log(prompt, resp, elapsed, err)
+	return resp, err
+}
+
+// The tier of the model used for the completion, for metric labels
+func (c *completion) tier() string {
+	if c.model == modelCheap {
+		return "cheap"
+	}
+	return "good"
 }
 
 func NewAI(good AIProvider, cheap AIProvider) *AI {
@@ -106,29 +291,70 @@ func (ai *AI) NewCompletion() *completion {
 // Creates a valid pull request review payload to be POSTed to the GitHub Rest API at /repos/{owner}/{repo}/pulls/{pull_number}/reviews.
 //
 // see https://docs.github.com/en/rest/pulls/reviews?apiVersion=2022-11-28#create-a-review-for-a-pull-request
-func (ai *AI) GeneratePullRequestReview(number int, title, description, prDiff string) (*github.PullRequestReviewRequest, int, error) {
-	notes, err := ai.generateReviewComments(number, title, description, prDiff)
+//
+// The codeContext is any additional source code (such as the full contents of the changed
+// files) that will help ground the review in the real code. It can be empty. The config
+// decides which comments are posted based on their severity, whether they are critiqued and
+// which event the review is posted with.
+func (ai *AI) GeneratePullRequestReview(ctx context.Context, details *PullRequestDetails, prDiff, codeContext string, config RepoConfig) (*github.PullRequestReviewRequest, *ReviewStats, error) {
+	// The stats are returned with errors too so that the tokens spent before the error
+	// are accounted for
+	stats := &ReviewStats{}
+
+	notes, err := ai.generateReviewComments(ctx, details, prDiff, codeContext)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Tokens += notes.Tokens
+	stats.Model = notes.Model
+
+	conformance, err := ai.generateConformanceReport(ctx, details, prDiff)
 	if err != nil {
-		return nil, 0, err
+		return nil, stats, err
 	}
+	stats.Tokens += conformance.Tokens
 
-	payload, body, err := ai.generateReviewBody(number, title, description, *&notes.Completion)
+	payload, body, err := ai.generateReviewBody(ctx, details, notes.Completion, conformance.Completion)
+	if body != nil {
+		stats.Tokens += body.Tokens
+	}
 	if err != nil {
-		return nil, 0, err
+		return nil, stats, err
 	}
 
-	ai.fixProblemsWithPayload(prDiff, payload)
+	// The payload was already parsed from the same completion so this won't fail
+	generated, _ := parseGeneratedComments(body.Completion)
+	addSuggestionsToPayload(prDiff, details.Files, payload, generated)
+	severities, belowSeverity := applySeverities(payload, generated, config)
+	stats.BelowSeverity = belowSeverity
+
+	ai.fixProblemsWithPayload(ctx, prDiff, payload)
+
+	// Duplicates are dropped before the critique so that no completions are spent on them
+	stats.Duplicates = dedupeComments(prDiff, payload, details.ExistingComments)
+
+	if config.Critique == CritiqueCheap || config.Critique == CritiqueGood {
+		critique, err := ai.critiqueComments(ctx, prDiff, payload, config.Critique)
+		if critique != nil {
+			stats.Tokens += critique.Tokens
+		}
+		if err != nil {
+			return nil, stats, err
+		}
+		stats.Critiqued = critique.Critiqued
+		stats.Dropped = critique.Dropped
+	}
 
-	tokens := body.Tokens + notes.Tokens
+	stats.Blockers = applyReviewPolicy(payload, severities, config)
+	addBadges(payload, severities)
 
-	return payload, tokens, nil
+	return payload, stats, nil
 }
 
-func (ai *AI) generateReviewComments(number int, title, description, prDiff string) (*CompletionResponse, error) {
-	details := formatPullRequestDetails(number, title, description)
-	message := fmt.Sprintf(reviewCommentsPrompt, details, ai.addPositionNumbersToDiff(prDiff))
+func (ai *AI) generateReviewComments(ctx context.Context, details *PullRequestDetails, prDiff, codeContext string) (*CompletionResponse, error) {
+	message := fmt.Sprintf(reviewCommentsPrompt, formatPullRequestDetails(details), formatCodeContext(codeContext), ai.addPositionNumbersToDiff(prDiff))
 
-	resp, err := ai.NewCompletion().Create(message)
+	resp, err := ai.NewCompletion().Create(ctx, message)
 	if err != nil {
 		return nil, err
 	}
@@ -136,32 +362,118 @@ func (ai *AI) generateReviewComments(number int, title, description, prDiff stri
 	return resp, nil
 }
 
-func (ai *AI) generateReviewBody(number int, title, description, notes string) (*github.PullRequestReviewRequest, *CompletionResponse, error) {
-	details := formatPullRequestDetails(number, title, description)
-	message := fmt.Sprintf(reviewPostBodyPrompt, details, notes)
+// Compare what the pull request title and description claim with what the diff actually
+// does. The completion is a list of mismatches or "noissues" when there aren't any. Nothing
+// is checked when the pull request has no description to compare against.
+func (ai *AI) generateConformanceReport(ctx context.Context, details *PullRequestDetails, prDiff string) (*CompletionResponse, error) {
+	if strings.TrimSpace(details.Description) == "" {
+		return &CompletionResponse{Completion: noissues}, nil
+	}
 
-	resp, err := ai.NewCompletion().Cheap().ReturnJSON().Create(message)
+	message := fmt.Sprintf(conformancePrompt, formatPullRequestDetails(details), prDiff)
+
+	resp, err := ai.NewCompletion().Create(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// The conformance report is added to the end of the generated body as its own section so
+// that it can't be lost or reworded by the model.
+func (ai *AI) generateReviewBody(ctx context.Context, details *PullRequestDetails, notes, conformance string) (*github.PullRequestReviewRequest, *CompletionResponse, error) {
+	message := fmt.Sprintf(reviewPostBodyPrompt, formatPullRequestDetails(details), notes)
+
+	resp, err := ai.NewCompletion().Cheap().ReturnJSON().Create(ctx, message)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// OpenAI claims that the response will always be valid json when using the JSON response format
+	_, span := startSpan(ctx, "parse_review")
 	var payload github.PullRequestReviewRequest
 	err = json.Unmarshal([]byte(resp.Completion), &payload)
+	span.SetAttributes(attribute.Int("nit.comments", len(payload.Comments)))
+	endSpan(span, err)
 	if err != nil {
-		return nil, nil, err
+		return nil, resp, err
+	}
+
+	if report := strings.TrimSpace(conformance); report != "" && report != noissues {
+		payload.Body = github.String(fmt.Sprintf(conformanceSection, payload.GetBody(), report))
 	}
 
 	return &payload, resp, nil
 }
 
+// The parts of a generated review comment that can't be sent to GitHub as they are.
+type generatedComment struct {
+	// The first position of a comment that spans multiple lines
+	StartPosition *int `json:"start_position,omitempty"`
+	// The change suggested for the lines of the comment
+	Suggestion *suggestion `json:"suggestion,omitempty"`
+	// How important the comment is (nit, minor, major or blocker)
+	Severity string `json:"severity,omitempty"`
+	// What the comment is about (bug, security, style, perf or test)
+	Category string `json:"category,omitempty"`
+}
+
+// Parse the generated review comments from the completion used to create the review
+// payload. The comments are in the same order as the comments of the payload.
+func parseGeneratedComments(completion string) ([]*generatedComment, error) {
+	var payload struct {
+		Comments []*generatedComment `json:"comments"`
+	}
+	err := json.Unmarshal([]byte(completion), &payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload.Comments, nil
+}
+
+// Generate a markdown description for a pull request with a summary, the changes made to
+// each file, the areas of risk and notes on how it was (or should be) tested.
+func (ai *AI) GeneratePullRequestDescription(ctx context.Context, details *PullRequestDetails, prDiff string) (*CompletionResponse, error) {
+	files := []string{}
+	for _, file := range parseDiffFiles(prDiff) {
+		files = append(files, file.Path)
+	}
+	message := fmt.Sprintf(describePrompt, formatPullRequestDetails(details), prDiff, strings.Join(files, "\n"))
+
+	resp, err := ai.NewCompletion().Create(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 // Create a reply for thread of GitHub comments on a particular pull request hunk. The output of the
 // string "noreply" indicates that no reply should be made (ie. the conversation has reached an end).
-func (ai *AI) GenerateCommentReply(comment, hunk string, allComments []*github.PullRequestComment, name string) (*CompletionResponse, error) {
+//
+// The code is the head version of the file around the lines the thread is on, formatted
+// with formatCommentedCode. It can be empty, otherwise the reply can include a suggested
+// change to the lines.
+func (ai *AI) GenerateCommentReply(ctx context.Context, comment, hunk, code string, allComments []*github.PullRequestComment, name string) (*CompletionResponse, error) {
 	thread := formatPullRequestComments(allComments)
-	message := fmt.Sprintf(commentReplyPrompt, comment, hunk, name, thread)
+	message := fmt.Sprintf(commentReplyPrompt, comment, hunk, code, name, thread)
+
+	resp, err := ai.NewCompletion().Create(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Create a reply to a comment that mentions the app in the conversation of a pull request
+// (or in the body of a review). The output of the string "noreply" indicates that no reply
+// should be made.
+func (ai *AI) GenerateMentionReply(ctx context.Context, details *PullRequestDetails, prDiff, comment, author string, conversation []*github.IssueComment, name string) (*CompletionResponse, error) {
+	message := fmt.Sprintf(mentionReplyPrompt, formatPullRequestDetails(details), prDiff, name, formatIssueComments(conversation), author, comment)
 
-	resp, err := ai.NewCompletion().Create(message)
+	resp, err := ai.NewCompletion().Create(ctx, message)
 	if err != nil {
 		return nil, err
 	}
@@ -169,14 +481,110 @@ func (ai *AI) GenerateCommentReply(comment, hunk string, allComments []*github.P
 	return resp, nil
 }
 
-// Build a prompt snippet for the details of a pull request
-func formatPullRequestDetails(number int, title string, description string) string {
-	return fmt.Sprintf(
+// The decision of the model on whether a review thread has been addressed.
+type threadResolution struct {
+	Resolved bool `json:"resolved"`
+	// What the author still needs to do when the thread isn't resolved
+	Missing string `json:"missing"`
+}
+
+// Check whether the concern raised in a review thread started by the app has been
+// addressed by the current changes in the pull request. The fileDiff is the current diff
+// of the file the thread is on, it can be empty when the file is no longer changed.
+func (ai *AI) generateThreadResolution(ctx context.Context, allComments []*github.PullRequestComment, hunk, fileDiff, name string) (*threadResolution, *CompletionResponse, error) {
+	if fileDiff == "" {
+		fileDiff = "The file is no longer changed in the pull request."
+	}
+	message := fmt.Sprintf(resolveThreadPrompt, name, formatPullRequestComments(allComments), hunk, fileDiff)
+
+	resp, err := ai.NewCompletion().ReturnJSON().Create(ctx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resolution threadResolution
+	err = json.Unmarshal([]byte(resp.Completion), &resolution)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return &resolution, resp, nil
+}
+
+type blockingIssues struct {
+	// The blocking issues that the current changes don't fix
+	Remaining []string `json:"remaining"`
+}
+
+// Check which of the blocking issues raised in review comments are still present in the
+// current diff of a pull request. Uses the cheap model since it only compares the
+// comments to the diff.
+func (ai *AI) generateBlockingIssues(ctx context.Context, blockers []*github.PullRequestComment, diff string) (*blockingIssues, *CompletionResponse, error) {
+	message := fmt.Sprintf(blockingIssuesPrompt, formatBlockingComments(blockers), diff)
+
+	resp, err := ai.NewCompletion().Cheap().ReturnJSON().Create(ctx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issues blockingIssues
+	err = json.Unmarshal([]byte(resp.Completion), &issues)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return &issues, resp, nil
+}
+
+// Build a prompt snippet for the details of a pull request. Output format looks like:
+//
+//	Pull Request #1
+//	Title: title
+//	description
+//
+//	Commits:
+//	- commit message
+//	...
+//
+//	Linked issue #2: title
+//	issue body
+//	Acceptance criteria:
+//	...
+func formatPullRequestDetails(details *PullRequestDetails) string {
+	result := fmt.Sprintf(
 		"Pull Request #%d\nTitle: %s\n%s",
-		number,
-		title,
-		description,
+		details.Number,
+		details.Title,
+		details.Description,
 	)
+
+	if len(details.Commits) > 0 {
+		result += "\n\nCommits:\n"
+		for _, message := range details.Commits {
+			result += fmt.Sprintf("- %s\n", strings.ReplaceAll(strings.TrimSpace(message), "\n", "\n  "))
+		}
+	}
+
+	for _, issue := range details.Issues {
+		body := truncateString(issue.GetBody(), maxIssueBodyLength)
+		result += fmt.Sprintf("\n\nLinked issue #%d: %s\n%s", issue.GetNumber(), issue.GetTitle(), body)
+		// Acceptance criteria are the most important part of an issue, make sure they
+		// aren't lost when long issues are truncated.
+		if criteria := extractAcceptanceCriteria(issue.GetBody()); criteria != "" && !strings.Contains(body, criteria) {
+			result += fmt.Sprintf("\nAcceptance criteria:\n%s", criteria)
+		}
+	}
+
+	return result
+}
+
+// Build a prompt snippet for the source code context of a pull request. Nothing is added
+// to the prompt when there is no context.
+func formatCodeContext(codeContext string) string {
+	if codeContext == "" {
+		return ""
+	}
+	return fmt.Sprintf(codeContextPrompt, codeContext)
 }
 
 // Build a prompt snippet for comments in a pull request thread. Output format looks like:
@@ -195,12 +603,54 @@ func formatPullRequestComments(comments []*github.PullRequestComment) string {
 	return result
 }
 
+// Build a prompt snippet for the blocking review comments. Output format looks like:
+//
+//	File path: path/to/file.go
+//	Diff hunk:
+//	@@ -1,2 +1,3 @@
+//	...
+//	Comment: comment
+//
+//	File path: path/to/file.go
+//	...
+func formatBlockingComments(comments []*github.PullRequestComment) string {
+	result := ""
+	for _, comment := range comments {
+		result += fmt.Sprintf("File path: %s\nDiff hunk:\n%s\nComment: %s\n\n", comment.GetPath(), comment.GetDiffHunk(), comment.GetBody())
+	}
+	return result
+}
+
+// Build a prompt snippet for the conversation comments on a pull request. Output format
+// looks like:
+//
+//	user1: comment
+//	user2: comment
+//	...
+func formatIssueComments(comments []*github.IssueComment) string {
+	result := ""
+	for _, comment := range comments {
+		user := comment.GetUser().GetLogin()
+		body := comment.GetBody()
+		result += fmt.Sprintf("%s: %s\n", user, body)
+	}
+	return result
+}
+
 // Check that the positions of the comments in a PR review are valid and fix any issues that
 // are found.
 // 1. Comments left a file not in the diff are removed.
 // 2. Comments left on a position that is out of range are fixed to the max position in the diff.
 // 3. Comments left on a diff with no hunk are removed.
-func (ai *AI) fixProblemsWithPayload(diff string, body *github.PullRequestReviewRequest) {
+//
+// Comments without a position have already been validated against the lines of the diff.
+func (ai *AI) fixProblemsWithPayload(ctx context.Context, diff string, body *github.PullRequestReviewRequest) {
+	_, span := startSpan(ctx, "fix_payload", attribute.Int("nit.comments", len(body.Comments)))
+	defer func() {
+		span.SetAttributes(attribute.Int("nit.comments_kept", len(body.Comments)))
+		endSpan(span, nil)
+	}()
+
 	// Maps to keep track of the start line of a file's changes and the total count.
 	// fileStarts maps file paths to their starting line in the diff.
 	// positionCounts maps file paths to their maximum valid position.
@@ -255,12 +705,15 @@ func (ai *AI) fixProblemsWithPayload(diff string, body *github.PullRequestReview
 		if !exists {
 			// Remove the comment.
 			body.Comments = append(body.Comments[:i], body.Comments[i+1:]...)
+			reviewComments.WithLabelValues(commentDropped).Inc()
 		} else if maxPos == 0 {
 			// This file has no diff hunk. As far as I can tell we can't leave a comment through the GitHub API.
 			body.Comments = append(body.Comments[:i], body.Comments[i+1:]...)
-		} else if *comment.Position > maxPos {
+			reviewComments.WithLabelValues(commentDropped).Inc()
+		} else if comment.Position != nil && *comment.Position > maxPos {
 			// Fix the comment position so the review goes through
 			*comment.Position = maxPos
+			reviewComments.WithLabelValues(commentRepositioned).Inc()
 		}
 	}
 }
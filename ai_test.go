@@ -0,0 +1,36 @@
+package nit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	t.Run("should accept known and empty settings", func(t *testing.T) {
+		config := &Config{
+			RepoConfig: RepoConfig{Describe: DescribeUpdate, InlineSeverity: SeverityMinor, Critique: CritiqueCheap},
+			Repos: map[string]RepoConfig{
+				"owner/repo": {ReviewPolicy: ReviewPolicyNeverApprove},
+			},
+		}
+
+		assert.NoError(t, config.Validate())
+	})
+
+	t.Run("should reject unknown settings", func(t *testing.T) {
+		config := &Config{RepoConfig: RepoConfig{SummarySeverity: "critical"}}
+
+		assert.ErrorContains(t, config.Validate(), `unknown summary severity "critical"`)
+	})
+
+	t.Run("should name the repository with unknown settings", func(t *testing.T) {
+		config := &Config{
+			Repos: map[string]RepoConfig{
+				"owner/repo": {Critique: "always"},
+			},
+		}
+
+		assert.ErrorContains(t, config.Validate(), `owner/repo: unknown critique "always"`)
+	})
+}
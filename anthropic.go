@@ -39,6 +39,7 @@ func (a *anthropicProvider) CreateCompletetion(req *CompletionRequest) (*Complet
 	resp := &CompletionResponse{
 		Completion: completion.Content[0].Text,
 		Tokens:     completion.Usage.InputTokens + completion.Usage.OutputTokens,
+		Model:      string(model),
 	}
 
 	return resp, nil
@@ -0,0 +1,37 @@
+package nit
+
+import "sync"
+
+// A small in-memory cache that evicts the oldest entry when it is full. Entries are never
+// replaced, the first value added for a key is kept until it is evicted.
+type boundedCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	entries map[K]V
+	order   []K
+}
+
+func newBoundedCache[K comparable, V any](size int) *boundedCache[K, V] {
+	return &boundedCache[K, V]{size: size, entries: map[K]V{}}
+}
+
+func (c *boundedCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.entries[key]
+	return value, ok
+}
+
+func (c *boundedCache[K, V]) add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.order) == c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = value
+	c.order = append(c.order, key)
+}
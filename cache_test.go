@@ -0,0 +1,33 @@
+package nit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundedCache(t *testing.T) {
+	t.Run("should evict the oldest entry when full", func(t *testing.T) {
+		cache := newBoundedCache[string, int](2)
+
+		cache.add("a", 1)
+		cache.add("b", 2)
+		cache.add("c", 3)
+
+		_, ok := cache.get("a")
+		assert.False(t, ok)
+		value, ok := cache.get("c")
+		assert.True(t, ok)
+		assert.Equal(t, 3, value)
+	})
+
+	t.Run("should keep the first value added for a key", func(t *testing.T) {
+		cache := newBoundedCache[string, int](2)
+
+		cache.add("a", 1)
+		cache.add("a", 2)
+
+		value, _ := cache.get("a")
+		assert.Equal(t, 1, value)
+	})
+}
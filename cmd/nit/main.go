@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/config"
+	"github.com/google/go-github/v59/github"
+)
+
+const usage = `Review a local diff without GitHub.
+
+Usage:
+  nit [flags] [revision range]
+
+The diff is read from the -diff file, from stdin when it isn't a terminal, from
+"git diff <revision range>" when a range is given, or from "git diff HEAD" otherwise.
+
+Flags:
+`
+
+// Untested...
+func main() {
+	flags := flag.NewFlagSet("nit", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	var opts options
+	flags.StringVar(&opts.DiffFile, "diff", "", `read the diff from a file, "-" for stdin`)
+	flags.StringVar(&opts.Dir, "C", ".", "the git checkout the diff is of")
+	flags.StringVar(&opts.Format, "format", formatMarkdown, "print the review as markdown, json or annotations")
+	flags.StringVar(&opts.Title, "title", "", "the title of the change, the subject of the last commit in the range by default")
+	flags.StringVar(&opts.Description, "description", "", "the description of the change")
+	flags.StringVar(&opts.Repo, "repo", "", `use the review settings of an "owner/repo" from the config`)
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	opts.Range = flags.Arg(0)
+
+	if err := run(opts, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "nit:", err)
+		os.Exit(1)
+	}
+}
+
+// How the review is printed
+const (
+	formatMarkdown    = "markdown"
+	formatJSON        = "json"
+	formatAnnotations = "annotations"
+)
+
+type options struct {
+	DiffFile    string
+	Dir         string
+	Range       string
+	Format      string
+	Title       string
+	Description string
+	Repo        string
+}
+
+func run(opts options, stdin *os.File, stdout io.Writer) error {
+	if opts.Format != formatMarkdown && opts.Format != formatJSON && opts.Format != formatAnnotations {
+		return fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	c, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Logs go to stderr so they don't get mixed up with the review
+	level := slog.LevelWarn
+	if c.Log.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+			return err
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	provider, err := newProvider(c.App)
+	if err != nil {
+		return err
+	}
+	ai := nit.NewAI(provider, provider)
+	ai.RedactContent = c.Log.Redact
+
+	repoConfig, err := newReviewConfig(c.Review, opts.Repo)
+	if err != nil {
+		return err
+	}
+
+	diff, readFile, commits, err := readChanges(opts, stdin)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return errors.New("the diff is empty")
+	}
+
+	details := &nit.PullRequestDetails{
+		Title:       opts.Title,
+		Description: opts.Description,
+		Commits:     commits,
+	}
+	if details.Title == "" && len(commits) > 0 {
+		details.Title = strings.SplitN(commits[len(commits)-1], "\n", 2)[0]
+	}
+
+	review, stats, err := nit.ReviewDiff(context.Background(), details, diff, readFile, repoConfig, ai)
+	if err != nil {
+		return err
+	}
+	slog.Info("generated review", "model", stats.Model, "tokens", stats.Tokens, "comments", len(review.Comments), "below_severity", stats.BelowSeverity)
+
+	return printReview(stdout, opts.Format, diff, review)
+}
+
+// Use OpenAI like the server does, or Anthropic when only its key is configured
+func newProvider(c config.AppConfig) (nit.AIProvider, error) {
+	switch {
+	case c.OpenaiKey != "":
+		return nit.NewOpenAI(c.OpenaiKey), nil
+	case c.AnthropicKey != "":
+		return nit.NewAnthropic(c.AnthropicKey), nil
+	default:
+		return nil, errors.New("no openai or anthropic key is configured")
+	}
+}
+
+// The review settings from the config, with the ones of the repository when one is given
+func newReviewConfig(c config.ReviewConfig, repo string) (nit.RepoConfig, error) {
+	reviewConfig := c.NitConfig()
+	if err := reviewConfig.Validate(); err != nil {
+		return nit.RepoConfig{}, err
+	}
+
+	if repo == "" {
+		return reviewConfig.RepoConfig, nil
+	}
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok {
+		return nit.RepoConfig{}, fmt.Errorf("the repo %q is not \"owner/repo\"", repo)
+	}
+	return reviewConfig.ForRepo(owner, name), nil
+}
+
+// Get the diff to review, a way to read the new version of each changed file and the
+// messages of the commits in the revision range.
+func readChanges(opts options, stdin *os.File) (string, func(string) (string, error), []string, error) {
+	root := opts.Dir
+	if out, err := git(opts.Dir, "rev-parse", "--show-toplevel"); err == nil {
+		root = strings.TrimSpace(out)
+	}
+	readWorkingTree := func(path string) (string, error) {
+		content, err := os.ReadFile(filepath.Join(root, path))
+		return string(content), err
+	}
+
+	switch {
+	case opts.DiffFile == "-":
+		diff, err := io.ReadAll(stdin)
+		return string(diff), readWorkingTree, nil, err
+
+	case opts.DiffFile != "":
+		diff, err := os.ReadFile(opts.DiffFile)
+		return string(diff), readWorkingTree, nil, err
+
+	case opts.Range == "" && !isTerminal(stdin):
+		diff, err := io.ReadAll(stdin)
+		return string(diff), readWorkingTree, nil, err
+
+	case opts.Range == "":
+		diff, err := git(opts.Dir, "diff", "HEAD")
+		return diff, readWorkingTree, nil, err
+	}
+
+	diff, err := git(opts.Dir, "diff", opts.Range)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// A single revision is diffed against the working tree
+	head, isRange := rangeHead(opts.Range)
+	if !isRange {
+		return diff, readWorkingTree, nil, nil
+	}
+
+	readRevision := func(path string) (string, error) {
+		return git(opts.Dir, "show", head+":"+path)
+	}
+
+	// Oldest first, like the commits of a pull request. The commits of "main...feature"
+	// are the ones on feature since it branched, the same as "main..feature".
+	logRange := strings.Replace(opts.Range, "...", "..", 1)
+	log, err := git(opts.Dir, "log", "--reverse", "--format=%B%x00", logRange)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	commits := []string{}
+	for _, message := range strings.Split(log, "\x00") {
+		if message = strings.TrimSpace(message); message != "" {
+			commits = append(commits, message)
+		}
+	}
+
+	return diff, readRevision, commits, nil
+}
+
+// The revision at the end of a range such as "main..feature" or "main...", HEAD when it
+// is left out. Returns false when it isn't a range.
+func rangeHead(revisions string) (string, bool) {
+	for _, separator := range []string{"...", ".."} {
+		if _, head, ok := strings.Cut(revisions, separator); ok {
+			if head == "" {
+				head = "HEAD"
+			}
+			return head, true
+		}
+	}
+	return "", false
+}
+
+// Run git in a directory and return what it printed
+func git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Whether the file is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Print the review in the format. Annotations are printed like compiler errors, so that
+// terminals and editors can jump to them, followed by the review body.
+func printReview(w io.Writer, format, diff string, review *github.PullRequestReviewRequest) error {
+	switch format {
+	case formatJSON:
+		payload, err := json.MarshalIndent(struct {
+			Review      *github.PullRequestReviewRequest `json:"review"`
+			Annotations []*nit.Annotation                `json:"annotations"`
+		}{review, nit.AnnotateReview(diff, review)}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(payload))
+		return err
+
+	case formatAnnotations:
+		for _, annotation := range nit.AnnotateReview(diff, review) {
+			location := annotation.Path
+			if annotation.Line != 0 {
+				location = fmt.Sprintf("%s:%d", annotation.Path, annotation.Line)
+			}
+			body := strings.ReplaceAll(strings.TrimSpace(annotation.Body), "\n", "\n    ")
+			if _, err := fmt.Fprintf(w, "%s: %s\n", location, body); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "\n%s: %s\n", review.GetEvent(), strings.TrimSpace(review.GetBody()))
+		return err
+
+	default:
+		_, err := fmt.Fprint(w, nit.FormatReviewMarkdown(review))
+		return err
+	}
+}
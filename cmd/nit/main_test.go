@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeHead(t *testing.T) {
+	t.Run("should get the revision at the end of a range", func(t *testing.T) {
+		tests := []struct {
+			Revisions string
+			Head      string
+			IsRange   bool
+		}{
+			{"main..feature", "feature", true},
+			{"main...feature", "feature", true},
+			{"main..", "HEAD", true},
+			{"HEAD~3", "", false},
+		}
+
+		for _, c := range tests {
+			head, isRange := rangeHead(c.Revisions)
+			assert.Equal(t, c.Head, head, c.Revisions)
+			assert.Equal(t, c.IsRange, isRange, c.Revisions)
+		}
+	})
+}
+
+func TestPrintReview(t *testing.T) {
+	diff := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3\n"
+	review := &github.PullRequestReviewRequest{
+		Body:  github.String("Some notes"),
+		Event: github.String("COMMENT"),
+		Comments: []*github.DraftReviewComment{
+			{Path: github.String("file.txt"), Position: github.Int(4), Body: github.String("Name this better\nlike this")},
+			{Path: github.String("file.txt"), Position: github.Int(2), Body: github.String("Why remove this?")},
+		},
+	}
+
+	t.Run("should print annotations on the lines of the files", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, printReview(&out, formatAnnotations, diff, review))
+
+		expected := "file.txt:3: Name this better\n    like this\nfile.txt: Why remove this?\n\nCOMMENT: Some notes\n"
+		assert.Equal(t, expected, out.String())
+	})
+
+	t.Run("should print the review and the annotations as JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, printReview(&out, formatJSON, diff, review))
+
+		var printed struct {
+			Review      *github.PullRequestReviewRequest `json:"review"`
+			Annotations []map[string]any                 `json:"annotations"`
+		}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &printed))
+		assert.Equal(t, review, printed.Review)
+		assert.Equal(t, float64(3), printed.Annotations[0]["line"])
+	})
+
+	t.Run("should print the review as markdown", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, printReview(&out, formatMarkdown, diff, review))
+
+		assert.Contains(t, out.String(), "### `file.txt` position 4\n\nName this better\nlike this\n")
+	})
+}
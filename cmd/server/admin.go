@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/store"
+	"github.com/google/go-github/v59/github"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// The number of runs shown on the dashboard and listed by the API when no limit is given
+	adminRunLimit = 50
+	// The most runs listed by the API at once
+	maxAdminRunLimit = 500
+	// The cookie and form value with the CSRF token of the dashboard
+	csrfCookie = "nit_csrf"
+	csrfField  = "csrf"
+)
+
+// The tokens spent on reviews of a repository.
+type repoSpend struct {
+	Repo   string `json:"repo"`
+	Runs   int    `json:"runs"`
+	Tokens int    `json:"tokens"`
+}
+
+var errReviewInProgress = errors.New("a review of the pull request is already in progress")
+
+// Serve the admin API and dashboard under /admin/. Every request must be authenticated with
+// the token, either as a bearer token or as the password of basic auth (so that it can be
+// used from a browser). Browsers send basic auth credentials with any request to the
+// server, so POST requests authenticated with basic auth must also have the CSRF token
+// that the dashboard puts in its forms.
+//
+//	GET  /admin/                    the dashboard
+//	POST /admin/retry               retry a run from the dashboard (form value "id")
+//	POST /admin/review              review a pull request from the dashboard (form values "owner", "repo" and "number")
+//	GET  /admin/api/runs            list runs, filtered by the "owner", "repo", "number", "outcome" and "limit" query values
+//	GET  /admin/api/runs/{id}       get a run
+//	POST /admin/api/runs/{id}/retry retry a run
+//	POST /admin/api/review          review a pull request (query values "owner", "repo" and "number")
+//	GET  /admin/api/spend           the tokens spent per repository
+func HandleAdmin(token string, webhookConfig *nit.Config, ai *nit.AI, gh *github.Client, runs store.Store, locks *nit.PullRequestLocks) http.Handler {
+	// Start a review of a pull request in the background
+	review := func(ctx context.Context, owner, repo string, number int) error {
+		pr, _, err := gh.PullRequests.Get(ctx, owner, repo, number)
+		if err != nil {
+			return err
+		}
+
+		sha := pr.GetHead().GetSHA()
+		if !locks.TryLock(owner, repo, number, sha) {
+			return errReviewInProgress
+		}
+
+		event := &github.PullRequestEvent{
+			Action:      github.String("opened"),
+			Repo:        pr.GetBase().GetRepo(),
+			PullRequest: pr,
+		}
+		logger := slog.Default().With("source", "admin", "repo", fmt.Sprintf("%s/%s", owner, repo), "pr", number)
+		done := nit.TrackQueue()
+		go func() {
+			defer done()
+			defer locks.Unlock(owner, repo, number, sha)
+
+			ctx, span := otel.Tracer(tracerName).Start(context.Background(), "admin review")
+			defer span.End()
+
+			if _, err := reviewPullRequest(ctx, event, webhookConfig, ai.WithLogger(logger), gh, runs); err != nil {
+				logger.Error("could not review pull request", "stage", "review", "error", err)
+			}
+		}()
+		return nil
+	}
+
+	retry := func(ctx context.Context, id int64) error {
+		run, err := runs.GetRun(id)
+		if err != nil {
+			return err
+		}
+		return review(ctx, run.Owner, run.Repo, run.Number)
+	}
+
+	return requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin"), "/")
+		parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+		switch {
+		case path == "" && r.Method == http.MethodGet:
+			serveDashboard(w, r, runs)
+		case path == "/retry" && r.Method == http.MethodPost:
+			id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if err := retry(r.Context(), id); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+		case path == "/review" && r.Method == http.MethodPost:
+			number, _ := strconv.Atoi(r.FormValue("number"))
+			if err := review(r.Context(), r.FormValue("owner"), r.FormValue("repo"), number); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+		case path == "/api/runs" && r.Method == http.MethodGet:
+			number, _ := strconv.Atoi(r.FormValue("number"))
+			limit, err := strconv.Atoi(r.FormValue("limit"))
+			if err != nil {
+				limit = adminRunLimit
+			}
+			limit = min(max(limit, 1), maxAdminRunLimit)
+			list, err := runs.ListRuns(store.RunFilter{
+				Owner:   r.FormValue("owner"),
+				Repo:    r.FormValue("repo"),
+				Number:  number,
+				Outcome: r.FormValue("outcome"),
+				Limit:   limit,
+			})
+			if err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+		case len(parts) == 3 && parts[0] == "api" && parts[1] == "runs" && r.Method == http.MethodGet:
+			id, _ := strconv.ParseInt(parts[2], 10, 64)
+			run, err := runs.GetRun(id)
+			if err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, run)
+		case len(parts) == 4 && parts[0] == "api" && parts[1] == "runs" && parts[3] == "retry" && r.Method == http.MethodPost:
+			id, _ := strconv.ParseInt(parts[2], 10, 64)
+			if err := retry(r.Context(), id); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		case path == "/api/review" && r.Method == http.MethodPost:
+			number, _ := strconv.Atoi(r.FormValue("number"))
+			if err := review(r.Context(), r.FormValue("owner"), r.FormValue("repo"), number); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		case path == "/api/spend" && r.Method == http.MethodGet:
+			spend, err := getSpend(runs)
+			if err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, spend)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// Only let requests authenticated with the token through. The token can be given as a
+// bearer token or as the password of basic auth. Requests that change anything and are
+// authenticated with basic auth must have a CSRF token matching their cookie.
+func requireToken(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		_, password, basic := r.BasicAuth()
+		if basic {
+			given = password
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nit"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if basic && r.Method != http.MethodGet && r.Method != http.MethodHead && !hasCSRFToken(r) {
+			http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// Check that the CSRF token in the form of a request matches the one in its cookie. Other
+// sites can make a browser send the cookie but can't read it to put it in the form.
+func hasCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.FormValue(csrfField)), []byte(cookie.Value)) == 1
+}
+
+// Get the CSRF token of the browser session from its cookie, setting a new one when there
+// isn't one yet.
+func getCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/admin/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// Sum the tokens spent on the recorded runs of each repository, most expensive first.
+func getSpend(runs store.Store) ([]*repoSpend, error) {
+	all, err := runs.ListRuns(store.RunFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	byRepo := map[string]*repoSpend{}
+	spend := []*repoSpend{}
+	for _, run := range all {
+		name := run.Owner + "/" + run.Repo
+		if byRepo[name] == nil {
+			byRepo[name] = &repoSpend{Repo: name}
+			spend = append(spend, byRepo[name])
+		}
+		byRepo[name].Runs++
+		byRepo[name].Tokens += run.Tokens
+	}
+
+	sort.SliceStable(spend, func(i, j int) bool {
+		return spend[i].Tokens > spend[j].Tokens
+	})
+	return spend, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("could not write response", "error", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ghErr *github.ErrorResponse
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errReviewInProgress):
+		status = http.StatusConflict
+	case errors.As(err, &ghErr) && ghErr.Response != nil:
+		status = ghErr.Response.StatusCode
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request, runs store.Store) {
+	csrf, err := getCSRFToken(w, r)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	recent, err := runs.ListRuns(store.RunFilter{Limit: adminRunLimit})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	failures, err := runs.ListRuns(store.RunFilter{Outcome: store.OutcomeFailed, Limit: adminRunLimit})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	spend, err := getSpend(runs)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = dashboardTemplate.Execute(w, map[string]interface{}{
+		"Recent":   recent,
+		"Failures": failures,
+		"Spend":    spend,
+		"CSRF":     csrf,
+	})
+	if err != nil {
+		slog.Warn("could not render dashboard", "error", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"pr": func(run *store.ReviewRun) string {
+		return fmt.Sprintf("%s/%s#%d", run.Owner, run.Repo, run.Number)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nit</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>nit</h1>
+
+<h2>Review a pull request</h2>
+<form method="post" action="/admin/review">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input name="owner" placeholder="owner" required>
+<input name="repo" placeholder="repo" required>
+<input name="number" placeholder="number" type="number" required>
+<button type="submit">Review</button>
+</form>
+
+<h2>Token spend</h2>
+<table>
+<tr><th>Repository</th><th>Runs</th><th>Tokens</th></tr>
+{{range .Spend}}<tr><td>{{.Repo}}</td><td>{{.Runs}}</td><td>{{.Tokens}}</td></tr>
+{{else}}<tr><td colspan="3">No runs yet</td></tr>
+{{end}}</table>
+
+<h2>Failures</h2>
+<table>
+<tr><th>Run</th><th>Time</th><th>Pull request</th><th>SHA</th><th>Error</th><th></th></tr>
+{{range .Failures}}<tr>
+<td>{{.ID}}</td><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td><td>{{pr .}}</td><td>{{printf "%.7s" .HeadSHA}}</td>
+<td class="failed">{{.Error}}</td>
+<td><form method="post" action="/admin/retry"><input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="id" value="{{.ID}}"><button type="submit">Retry</button></form></td>
+</tr>
+{{else}}<tr><td colspan="6">No failures</td></tr>
+{{end}}</table>
+
+<h2>Recent runs</h2>
+<table>
+<tr><th>Run</th><th>Time</th><th>Pull request</th><th>SHA</th><th>Outcome</th><th>Model</th><th>Tokens</th><th>Latency</th><th>Comments</th><th>Dropped</th><th>Duplicates</th><th></th></tr>
+{{range .Recent}}<tr>
+<td>{{.ID}}</td><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td><td>{{pr .}}</td><td>{{printf "%.7s" .HeadSHA}}</td>
+<td{{if eq .Outcome "failed"}} class="failed"{{end}}>{{.Outcome}}{{if .Payload}} (<a href="/admin/api/runs/{{.ID}}">payload</a>){{end}}</td><td>{{.Model}}</td><td>{{.Tokens}}</td><td>{{.Latency}}</td><td>{{len .CommentIDs}}</td><td>{{.Dropped}}</td><td>{{.Duplicates}}</td>
+<td><form method="post" action="/admin/review"><input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="owner" value="{{.Owner}}"><input type="hidden" name="repo" value="{{.Repo}}"><input type="hidden" name="number" value="{{.Number}}"><button type="submit">Re-review</button></form></td>
+</tr>
+{{else}}<tr><td colspan="12">No runs yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
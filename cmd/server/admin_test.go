@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/store"
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAdmin(t *testing.T) {
+	const token = "secret"
+
+	pullRequest := &github.PullRequest{
+		Number: github.Int(1),
+		Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+		Base: &github.PullRequestBranch{Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("owner")},
+		}},
+	}
+
+	setup := func(t *testing.T) (http.Handler, store.Store, *nit.PullRequestLocks) {
+		runs := store.NewMemoryStore()
+		for _, run := range []*store.ReviewRun{
+			{Owner: "owner", Repo: "repo", Number: 1, Tokens: 100, Outcome: store.OutcomePosted},
+			{Owner: "owner", Repo: "repo", Number: 1, Tokens: 50, Outcome: store.OutcomeFailed, Error: "something happened"},
+			{Owner: "owner", Repo: "other", Number: 2, Tokens: 500, Outcome: store.OutcomePosted},
+		} {
+			assert.NoError(t, runs.SaveRun(run))
+		}
+
+		// Reviews started from the admin API get the pull request and then fail to get the diff
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.Contains(r.Header.Get("Accept"), "diff") {
+						ghMock.WriteError(w, http.StatusNotFound, "not found")
+						return
+					}
+					w.Write(ghMock.MustMarshal(pullRequest))
+				}),
+			),
+		))
+		provider := &staticProvider{}
+		locks := nit.NewPullRequestLocks()
+
+		return HandleAdmin(token, &nit.Config{}, nit.NewAI(provider, provider), mockGithub, runs, locks), runs, locks
+	}
+
+	request := func(handler http.Handler, method, target string, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if auth != nil {
+			auth(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	t.Run("should reject requests without the token", func(t *testing.T) {
+		handler, _, _ := setup(t)
+
+		assert.Equal(t, http.StatusUnauthorized, request(handler, http.MethodGet, "/admin/api/runs", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, http.MethodGet, "/admin/api/runs", bearer("wrong")).Code)
+		assert.Equal(t, http.StatusUnauthorized, request(handler, http.MethodGet, "/admin/", func(r *http.Request) {
+			r.SetBasicAuth("admin", "wrong")
+		}).Code)
+		assert.Equal(t, http.StatusOK, request(handler, http.MethodGet, "/admin/api/runs", bearer(token)).Code)
+	})
+
+	t.Run("should list runs filtered by outcome", func(t *testing.T) {
+		handler, _, _ := setup(t)
+
+		var all, failed []*store.ReviewRun
+		rec := request(handler, http.MethodGet, "/admin/api/runs", bearer(token))
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&all))
+		rec = request(handler, http.MethodGet, "/admin/api/runs?outcome=failed", bearer(token))
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&failed))
+
+		assert.Len(t, all, 3)
+		assert.Len(t, failed, 1)
+		assert.Equal(t, "something happened", failed[0].Error)
+	})
+
+	t.Run("should list at least one run", func(t *testing.T) {
+		handler, _, _ := setup(t)
+
+		var list []*store.ReviewRun
+		rec := request(handler, http.MethodGet, "/admin/api/runs?limit=-5", bearer(token))
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+
+		assert.Len(t, list, 1)
+	})
+
+	t.Run("should retry a failed run", func(t *testing.T) {
+		handler, runs, locks := setup(t)
+		failed, err := runs.ListRuns(store.RunFilter{Outcome: store.OutcomeFailed})
+		assert.NoError(t, err)
+
+		rec := request(handler, http.MethodPost, "/admin/api/runs/"+strconv.FormatInt(failed[0].ID, 10)+"/retry", bearer(token))
+		assert.NoError(t, locks.Wait(context.Background()))
+
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		// the retry is recorded as a new run of the same pull request
+		retried, err := runs.ListRuns(store.RunFilter{Owner: "owner", Repo: "repo", Number: 1})
+		assert.NoError(t, err)
+		assert.Len(t, retried, 3)
+	})
+
+	t.Run("should not review a pull request that is already being reviewed", func(t *testing.T) {
+		handler, _, locks := setup(t)
+		assert.True(t, locks.TryLock("owner", "repo", 1, "abc123"))
+		defer locks.Unlock("owner", "repo", 1, "abc123")
+
+		rec := request(handler, http.MethodPost, "/admin/api/review?owner=owner&repo=repo&number=1", bearer(token))
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("should sum the tokens spent per repository", func(t *testing.T) {
+		handler, _, _ := setup(t)
+
+		var spend []*repoSpend
+		rec := request(handler, http.MethodGet, "/admin/api/spend", bearer(token))
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&spend))
+
+		assert.Equal(t, []*repoSpend{
+			{Repo: "owner/other", Runs: 1, Tokens: 500},
+			{Repo: "owner/repo", Runs: 2, Tokens: 150},
+		}, spend)
+	})
+
+	t.Run("should require the CSRF token of the dashboard for forms", func(t *testing.T) {
+		handler, _, locks := setup(t)
+		basic := func(r *http.Request) { r.SetBasicAuth("admin", token) }
+
+		// the dashboard sets the token in a cookie and puts it in its forms
+		dashboard := request(handler, http.MethodGet, "/admin/", basic)
+		assert.Equal(t, http.StatusOK, dashboard.Code)
+		cookies := dashboard.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Contains(t, dashboard.Body.String(), `name="csrf" value="`+cookies[0].Value+`"`)
+
+		post := func(csrf string) int {
+			form := url.Values{"owner": {"owner"}, "repo": {"repo"}, "number": {"1"}, "csrf": {csrf}}
+			req := httptest.NewRequest(http.MethodPost, "/admin/review", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth("admin", token)
+			req.AddCookie(cookies[0])
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		assert.Equal(t, http.StatusForbidden, post(""))
+		assert.Equal(t, http.StatusForbidden, post("forged"))
+		assert.Equal(t, http.StatusSeeOther, post(cookies[0].Value))
+		assert.NoError(t, locks.Wait(context.Background()))
+	})
+}
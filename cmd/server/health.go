@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/config"
+)
+
+// How long each readiness check can take when no timeout is configured
+const defaultReadyTimeout = 5 * time.Second
+
+// Something that must be working for the server to be ready to handle webhooks
+type readinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// The server is alive as long as it can respond
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Run every check, each within the timeout, and respond with the result of each one. The
+// status is 503 when any of them fail.
+func HandleReady(timeout time.Duration, checks []readinessCheck) http.HandlerFunc {
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		results := map[string]string{}
+		for _, check := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			err := check.Check(ctx)
+			cancel()
+
+			if err != nil {
+				status = http.StatusServiceUnavailable
+				results[check.Name] = err.Error()
+			} else {
+				results[check.Name] = "ok"
+			}
+		}
+
+		writeJSON(w, status, results)
+	}
+}
+
+// Check that the settings needed to handle webhooks are set and known
+func checkConfig(c *config.Config, webhookConfig *nit.Config) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var errs []error
+		if c.App.GithubToken == "" {
+			errs = append(errs, errors.New("no github token"))
+		}
+		if c.App.OpenaiKey == "" {
+			errs = append(errs, errors.New("no openai key"))
+		}
+		errs = append(errs, webhookConfig.Validate())
+		return errors.Join(errs...)
+	}
+}
+
+// Check that a URL responds. Any response other than a server error counts, an
+// unauthenticated request is enough to know that the service can be reached.
+func checkReachable(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s responded with %s", url, resp.Status)
+		}
+		return nil
+	}
+}
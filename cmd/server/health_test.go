@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleReady(t *testing.T) {
+	validConfig := &config.Config{App: config.AppConfig{GithubToken: "token", OpenaiKey: "key"}}
+
+	ready := func(checks []readinessCheck) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		HandleReady(time.Second, checks)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		results := map[string]string{}
+		json.Unmarshal(rec.Body.Bytes(), &results)
+		return rec.Code, results
+	}
+
+	t.Run("should be ready when the config is valid and the stand-ins respond", func(t *testing.T) {
+		github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer github.Close()
+
+		code, results := ready([]readinessCheck{
+			{Name: "config", Check: checkConfig(validConfig, &nit.Config{})},
+			{Name: "github", Check: checkReachable(github.Client(), github.URL)},
+		})
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, map[string]string{"config": "ok", "github": "ok"}, results)
+	})
+
+	t.Run("should not be ready when a stand-in fails", func(t *testing.T) {
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer provider.Close()
+
+		code, results := ready([]readinessCheck{
+			{Name: "provider", Check: checkReachable(provider.Client(), provider.URL)},
+		})
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, results["provider"], "502 Bad Gateway")
+	})
+
+	t.Run("should not be ready when the config is invalid", func(t *testing.T) {
+		code, results := ready([]readinessCheck{
+			{Name: "config", Check: checkConfig(&config.Config{}, &nit.Config{RepoConfig: nit.RepoConfig{Describe: "always"}})},
+		})
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, results["config"], "no github token")
+		assert.Contains(t, results["config"], `unknown describe "always"`)
+	})
+
+	t.Run("should give up on checks that take longer than the timeout", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		HandleReady(10*time.Millisecond, []readinessCheck{
+			{Name: "slow", Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+		})(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
@@ -1,90 +1,504 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/evanmcneely/nit"
 	"github.com/evanmcneely/nit/internal/config"
+	"github.com/evanmcneely/nit/internal/store"
 	"github.com/google/go-github/v59/github"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Untested...
 func main() {
+	// Replay recorded webhook deliveries instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load the config
 	config, err := config.GetConfig()
 	if err != nil {
 		panic(fmt.Sprintf("failed to load config: %v", err))
 	}
 
+	// Initialize the logger
+	logger, err := newLogger(config.Log)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create logger: %v", err))
+	}
+	slog.SetDefault(logger)
+
+	// Initialize tracing
+	shutdownTracing, err := newTracerProvider(config.Tracing)
+	if err != nil {
+		panic(fmt.Sprintf("failed to initialize tracing: %v", err))
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize AI providers
 	openai := nit.NewOpenAI(config.App.OpenaiKey)
 	ai := nit.NewAI(openai, openai)
+	ai.RedactContent = config.Log.Redact
 
 	// Initialize Github client
-	gh := github.NewClient(nil).WithAuthToken(config.App.GithubToken)
+	gh := github.NewClient(&http.Client{Transport: nit.NewMetricsTransport(nil)}).WithAuthToken(config.App.GithubToken)
+
+	// Initialize the store for review runs
+	runs, err := newStore(config.App.Database)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open the database: %v", err))
+	}
+	defer runs.Close()
+
+	webhookConfig, err := newWebhookConfig(&config, gh, runs)
+	if err != nil {
+		panic(fmt.Sprintf("failed to configure webhooks: %v", err))
+	}
+	locks := nit.NewPullRequestLocks()
 
 	// Define the handler function.
-	http.HandleFunc("/webhooks/github", HandleGithubEvents(&config, ai, gh))
+	http.HandleFunc("/webhooks/github", HandleGithubEvents(&config, webhookConfig, ai, gh, runs, locks))
+	http.Handle("/metrics", promhttp.Handler())
+
+	// The admin API and dashboard are only served when a token is configured
+	if config.App.AdminToken != "" {
+		http.Handle("/admin/", HandleAdmin(config.App.AdminToken, webhookConfig, ai, gh, runs, locks))
+	}
+
+	// Health checks
+	http.HandleFunc("/healthz", HandleHealth)
+	http.HandleFunc("/readyz", HandleReady(config.Server.ReadyTimeout, newReadinessChecks(&config, webhookConfig)))
 
 	// Start the server
-	log.Printf("server starting on port %v...", config.App.Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", config.App.Port), nil)
-	if err != nil {
-		log.Printf("error starting server: %v", err)
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%v", config.App.Port),
+		ReadTimeout:  config.Server.ReadTimeout,
+		WriteTimeout: config.Server.WriteTimeout,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		slog.Info("server starting", "port", config.App.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("could not start server", "error", err)
+			stop()
+		}
+	}()
+
+	// Stop taking new deliveries once signalled and give the ones being handled (and the
+	// reviews started from the admin API) until the shutdown timeout to finish
+	<-ctx.Done()
+	slog.Info("server shutting down", "timeout", config.Server.ShutdownTimeout)
+
+	shutdownCtx := context.Background()
+	if config.Server.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, config.Server.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("could not finish handling deliveries", "error", err)
+	}
+	if err := locks.Wait(shutdownCtx); err != nil {
+		slog.Error("could not finish reviews", "error", err)
 	}
 }
 
-// Handle Github webhook events for Pull Requests and Pull Request Comments
-func HandleGithubEvents(c *config.Config, ai *nit.AI, gh *github.Client) http.HandlerFunc {
-	webhookConfig := &nit.Config{
-		OptIn:   c.Review.OptIn,
-		AppName: c.Review.Name,
+// The checks that must pass for the server to be ready: the config is valid, GitHub and
+// the AI provider can be reached
+func newReadinessChecks(c *config.Config, webhookConfig *nit.Config) []readinessCheck {
+	client := &http.Client{}
+	checks := []readinessCheck{
+		{Name: "config", Check: checkConfig(c, webhookConfig)},
+	}
+	if c.Server.GithubURL != "" {
+		checks = append(checks, readinessCheck{Name: "github", Check: checkReachable(client, c.Server.GithubURL)})
+	}
+	if c.Server.ProviderURL != "" {
+		checks = append(checks, readinessCheck{Name: "provider", Check: checkReachable(client, c.Server.ProviderURL)})
+	}
+	return checks
+}
+
+// How long webhook delivery IDs are remembered to skip redeliveries
+const deliveryTTL = 24 * time.Hour
+
+// Build the nit config from the app config
+func newWebhookConfig(c *config.Config, gh *github.Client, runs store.Store) (*nit.Config, error) {
+	webhookConfig := c.Review.NitConfig()
+
+	// Invalid repo policies stop the server from starting rather than being ignored
+	if err := webhookConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Dry runs are logged unless they are written somewhere else
+	switch c.Review.DryRunOutput {
+	case "log", "":
+	case "store":
+		webhookConfig.DryRunWriter = &storeDryRunWriter{runs: runs}
+	case "dir":
+		writer, err := nit.NewDirDryRunWriter(c.Review.DryRunDir)
+		if err != nil {
+			return nil, err
+		}
+		webhookConfig.DryRunWriter = writer
+	default:
+		return nil, fmt.Errorf("unknown dry run output %q", c.Review.DryRunOutput)
 	}
 
+	// Get the user the token belongs to so that our own comments can be ignored
+	if user, _, err := gh.Users.Get(context.Background(), ""); err != nil {
+		slog.Warn("could not get the authenticated user", "error", err)
+	} else {
+		webhookConfig.UserID = user.GetID()
+	}
+
+	return webhookConfig, nil
+}
+
+// Handle Github webhook events for Pull Requests and Pull Request Comments
+func HandleGithubEvents(c *config.Config, webhookConfig *nit.Config, ai *nit.AI, gh *github.Client, runs store.Store, locks *nit.PullRequestLocks) http.HandlerFunc {
+	deliveries := nit.NewDeliveryStore(deliveryTTL)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
+		eventType := github.WebHookType(r)
+
 		payload, err := github.ValidatePayload(r, []byte(c.App.WebhookSecret))
 		if err != nil {
-			log.Printf("could not validate payload: %v", err)
+			nit.RecordWebhook(eventType, "", "invalid")
+			slog.Warn("could not validate payload", "delivery", github.DeliveryID(r), "event", eventType, "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		event, err := github.ParseWebHook(github.WebHookType(r), payload)
+		event, err := github.ParseWebHook(eventType, payload)
 		if err != nil {
-			log.Printf("could not parse webhook: %v", err)
+			nit.RecordWebhook(eventType, "", "invalid")
+			slog.Warn("could not parse webhook", "delivery", github.DeliveryID(r), "event", eventType, "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		// Acknowledge receipt of the payload
+		action := ""
+		if e, ok := event.(interface{ GetAction() string }); ok {
+			action = e.GetAction()
+		}
+
+		// Everything logged while handling the delivery is correlated by its ID
+		logger := deliveryLogger(github.DeliveryID(r), eventType, action, event)
+
+		// The span outlives the request, GitHub stops waiting for a response long before
+		// reviews are done
+		ctx, span := otel.Tracer(tracerName).Start(
+			context.WithoutCancel(r.Context()),
+			"webhook "+eventType,
+			trace.WithAttributes(
+				attribute.String("nit.delivery", github.DeliveryID(r)),
+				attribute.String("nit.event", eventType),
+				attribute.String("nit.action", action),
+			),
+		)
+		defer span.End()
+
+		ai := ai.WithLogger(logger)
+
+		// Acknowledge redeliveries of events that have already been handled without
+		// handling them again
+		if deliveries.Seen(github.DeliveryID(r)) {
+			nit.RecordWebhook(eventType, action, "duplicate")
+			logger.Info("ignoring duplicate delivery")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Handling the delivery again is left to a redelivery when anything fails
+		ran, failed := false, false
+		defer func() {
+			if failed {
+				deliveries.Forget(github.DeliveryID(r))
+			}
+		}()
+		report := func(stage, message string, err error) {
+			ran = true
+			if err != nil {
+				failed = true
+				logger.Error(message, "stage", stage, "error", err)
+			}
+		}
+
+		// Acknowledge receipt of the payload before handling it. The response is flushed so
+		// that GitHub isn't kept waiting (and the write timeout doesn't cut it off) while the
+		// delivery is handled.
 		w.WriteHeader(http.StatusNoContent)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		done := nit.TrackQueue()
+		defer done()
 
 		switch event := event.(type) {
 		case *github.PullRequestEvent:
+			var (
+				owner  = event.GetRepo().GetOwner().GetLogin()
+				repo   = event.GetRepo().GetName()
+				number = event.GetPullRequest().GetNumber()
+				sha    = event.GetPullRequest().GetHead().GetSHA()
+			)
+			// Deliveries for the same changes are handled one at a time so that the work
+			// (such as posting a review) isn't done twice
+			if !locks.TryLock(owner, repo, number, sha) {
+				nit.RecordWebhook(eventType, action, "duplicate")
+				logger.Info("not handling pull request", "reason", "already in progress", "sha", sha)
+				return
+			}
+			defer locks.Unlock(owner, repo, number, sha)
+
+			if ok, reason := nit.ShouldDescribePullRequest(ctx, event, gh, webhookConfig); !ok {
+				nit.RecordSkip("describe", reason)
+				logger.Info("not describing pull request", "stage", "describe", "reason", reason)
+			} else {
+				_, err := nit.DescribePullRequest(ctx, event, webhookConfig, ai, gh)
+				report("describe", "could not describe pull request", err)
+			}
+
 			if ok, reason := nit.ShouldReviewPullRequest(event, webhookConfig); !ok {
-				log.Printf("not reviewing pull request because: %v", reason)
+				nit.RecordSkip("review", reason)
+				logger.Info("not reviewing pull request", "stage", "review", "reason", reason)
+			} else {
+				_, err := reviewPullRequest(ctx, event, webhookConfig, ai, gh, runs)
+				report("review", "could not review pull request", err)
 			}
-			_, err = nit.ReviewPullRequest(event, ai, gh)
-			if err != nil {
-				log.Printf("error reviewing pull request: %v", err)
+
+			if ok, reason := nit.ShouldDismissStaleReview(event, webhookConfig); !ok {
+				nit.RecordSkip("dismiss", reason)
+				logger.Info("not dismissing stale review", "stage", "dismiss", "reason", reason)
+			} else {
+				_, err := nit.DismissStaleReview(ctx, event, webhookConfig, ai, gh)
+				report("dismiss", "could not dismiss stale review", err)
+			}
+
+			if ok, reason := nit.ShouldResolveThreads(event, webhookConfig); !ok {
+				nit.RecordSkip("resolve", reason)
+				logger.Info("not resolving review threads", "stage", "resolve", "reason", reason)
+			} else {
+				_, err := nit.ResolveThreads(ctx, event, webhookConfig, ai, gh)
+				report("resolve", "could not resolve review threads", err)
 			}
 		case *github.PullRequestReviewCommentEvent:
-			if ok, reason := nit.ShouldRespondToComment(event, gh, webhookConfig); !ok {
-				log.Printf("not replying to comment because: %v", reason)
+			if ok, reason := nit.ShouldRespondToComment(ctx, event, gh, webhookConfig); !ok {
+				nit.RecordSkip("reply", reason)
+				logger.Info("not replying to comment", "stage", "reply", "reason", reason)
+			} else {
+				_, err := nit.RespondToComment(ctx, event, webhookConfig, ai, gh)
+				report("reply", "could not reply to comment", err)
 			}
-			_, err = nit.RespondToComment(event, webhookConfig, ai, gh)
-			if err != nil {
-				log.Printf("error replying to comment: %v", err)
+		case *github.IssueCommentEvent:
+			if ok, reason := nit.ShouldDescribeOnCommand(event, webhookConfig); !ok {
+				nit.RecordSkip("describe_command", reason)
+				logger.Info("not describing pull request", "stage", "describe_command", "reason", reason)
+			} else {
+				_, err := nit.DescribePullRequestOnCommand(ctx, event, webhookConfig, ai, gh)
+				report("describe_command", "could not describe pull request", err)
+			}
+
+			if ok, reason := nit.ShouldRespondToMention(event, webhookConfig); !ok {
+				nit.RecordSkip("mention", reason)
+				logger.Info("not replying to mention", "stage", "mention", "reason", reason)
+			} else {
+				_, err := nit.RespondToMention(ctx, event, webhookConfig, ai, gh)
+				report("mention", "could not reply to mention", err)
+			}
+		case *github.PullRequestReviewEvent:
+			if ok, reason := nit.ShouldRespondToReviewMention(event, webhookConfig); !ok {
+				nit.RecordSkip("review_mention", reason)
+				logger.Info("not replying to review mention", "stage", "review_mention", "reason", reason)
+			} else {
+				_, err := nit.RespondToReviewMention(ctx, event, webhookConfig, ai, gh)
+				report("review_mention", "could not reply to review mention", err)
 			}
 		default:
-			log.Printf("ignoring event %s", r.Header.Get("X-GitHub-Event"))
+			nit.RecordWebhook(eventType, action, "ignored")
+			logger.Info("ignoring event")
+			return
 		}
+
+		outcome := "handled"
+		switch {
+		case failed:
+			outcome = "error"
+		case !ran:
+			outcome = "skipped"
+		}
+		nit.RecordWebhook(eventType, action, outcome)
+	}
+}
+
+// Create the logger of the app from the log config
+func newLogger(c config.LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.Format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", c.Format)
+	}
+}
+
+// The name of the spans created by the server
+const tracerName = "github.com/evanmcneely/nit/cmd/server"
+
+// Export spans to the configured OTLP collector. Returns the function that flushes and
+// stops the exporter. Spans are not recorded when no endpoint is configured.
+func newTracerProvider(c config.TracingConfig) (func(context.Context) error, error) {
+	if c.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "nit"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// The logger for a webhook delivery. The delivery ID correlates every line logged while
+// handling it, along with the repository and pull request the event is about.
+func deliveryLogger(delivery, eventType, action string, event interface{}) *slog.Logger {
+	logger := slog.Default().With("delivery", delivery, "event", eventType, "action", action)
+
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok {
+		logger = logger.With("repo", fmt.Sprintf("%s/%s", e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName()))
+	}
+
+	number := 0
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		number = e.GetPullRequest().GetNumber()
+	case *github.PullRequestReviewCommentEvent:
+		number = e.GetPullRequest().GetNumber()
+	case *github.PullRequestReviewEvent:
+		number = e.GetPullRequest().GetNumber()
+	case *github.IssueCommentEvent:
+		number = e.GetIssue().GetNumber()
+	}
+	if number != 0 {
+		logger = logger.With("pr", number)
+	}
+	return logger
+}
+
+// Open the store for review runs. Runs are kept in memory when no database is configured.
+func newStore(database string) (store.Store, error) {
+	if database == "" {
+		return store.NewMemoryStore(), nil
+	}
+	return store.NewSQLiteStore(database)
+}
+
+// Records dry runs in the store of review runs
+type storeDryRunWriter struct {
+	runs store.Store
+}
+
+func (w *storeDryRunWriter) WriteDryRun(dryRun *nit.DryRun) error {
+	payload, err := json.Marshal(dryRun)
+	if err != nil {
+		return err
+	}
+
+	return w.runs.SaveRun(&store.ReviewRun{
+		Owner:         dryRun.Owner,
+		Repo:          dryRun.Repo,
+		Number:        dryRun.Number,
+		HeadSHA:       dryRun.HeadSHA,
+		PromptVersion: nit.PromptVersion,
+		Model:         dryRun.Model,
+		Tokens:        dryRun.Tokens,
+		Outcome:       store.OutcomeDryRun,
+		Payload:       string(payload),
+	})
+}
+
+// Review a pull request and record the run in the store. Dry runs aren't recorded, they
+// are written by the dry run writer.
+func reviewPullRequest(ctx context.Context, event *github.PullRequestEvent, c *nit.Config, ai *nit.AI, gh *github.Client, runs store.Store) (*nit.ReviewResponse, error) {
+	start := time.Now()
+	resp, err := nit.ReviewPullRequest(ctx, event, c, ai, gh)
+	if resp != nil && resp.DryRun != nil {
+		return resp, err
+	}
+
+	run := &store.ReviewRun{
+		Owner:         event.GetRepo().GetOwner().GetLogin(),
+		Repo:          event.GetRepo().GetName(),
+		Number:        event.GetPullRequest().GetNumber(),
+		HeadSHA:       event.GetPullRequest().GetHead().GetSHA(),
+		PromptVersion: nit.PromptVersion,
+		Latency:       time.Since(start),
+		Outcome:       store.OutcomePosted,
+	}
+	if resp != nil {
+		run.Model = resp.Model
+		run.Tokens = resp.Tokens
+		run.ReviewID = resp.Id
+		run.CommentIDs = resp.CommentIds
+		run.Dropped = resp.Dropped
+		run.Duplicates = resp.Duplicates
+	}
+	if err != nil {
+		run.Outcome = store.OutcomeFailed
+		run.Error = err.Error()
+	}
+
+	if saveErr := runs.SaveRun(run); saveErr != nil {
+		ai.Logger().Error("could not record review run", "stage", "review", "error", saveErr)
 	}
+	return resp, err
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/config"
+	"github.com/evanmcneely/nit/internal/store"
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Completes every prompt with the same completion
+type staticProvider struct {
+	completion string
+}
+
+func (p *staticProvider) CreateCompletetion(req *nit.CompletionRequest) (*nit.CompletionResponse, error) {
+	return &nit.CompletionResponse{Completion: p.completion, Tokens: 10, Model: "gpt-4o"}, nil
+}
+
+// Completes every prompt with a function
+type providerFunc func(req *nit.CompletionRequest) (*nit.CompletionResponse, error)
+
+func (f providerFunc) CreateCompletetion(req *nit.CompletionRequest) (*nit.CompletionResponse, error) {
+	return f(req)
+}
+
+// Get the number of webhook deliveries counted with an outcome
+func webhookCount(t *testing.T, action, outcome string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "nit_webhook_events_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["event"] == "pull_request" && labels["action"] == action && labels["outcome"] == outcome {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// Record a pull request event delivery to handle with replayInProcess
+func pullRequestRecording(t *testing.T, delivery, action, description string) *recording {
+	payload, err := json.Marshal(&github.PullRequestEvent{
+		Action: github.String(action),
+		Number: github.Int(1),
+		PullRequest: &github.PullRequest{
+			Number: github.Int(1),
+			State:  github.String("open"),
+			Title:  github.String("Add line 3"),
+			Body:   github.String(description),
+			User:   &github.User{Login: github.String("octocat")},
+			Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+			Base:   &github.PullRequestBranch{SHA: github.String("def456")},
+		},
+		Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("owner")},
+		},
+	})
+	assert.NoError(t, err)
+
+	return &recording{
+		Headers: map[string]string{
+			github.EventTypeHeader:  "pull_request",
+			github.DeliveryIDHeader: delivery,
+		},
+		Payload: payload,
+	}
+}
+
+func TestHandleGithubEvents(t *testing.T) {
+	c := &config.Config{
+		App: config.AppConfig{WebhookSecret: "secret"},
+		Review: config.ReviewConfig{
+			OptIn:      true,
+			Name:       "nit",
+			RepoConfig: config.RepoConfig{Describe: "off"},
+		},
+	}
+
+	t.Run("should only review pull requests that should be reviewed", func(t *testing.T) {
+		recordings := []*recording{
+			// review not requested when opt-in is enabled
+			pullRequestRecording(t, "1", "opened", "Adds a line"),
+			// not opened
+			pullRequestRecording(t, "2", "closed", "Adds a line. ai-review:please"),
+			// marked as ignore
+			pullRequestRecording(t, "3", "opened", "ai-review:please ai-review:ignore"),
+		}
+
+		results, err := replayInProcess(c, replayAIFake, recordings)
+
+		assert.NoError(t, err)
+		for _, result := range results {
+			assert.Equal(t, http.StatusNoContent, result.Status, result.Delivery)
+			assert.Empty(t, result.Writes, result.Delivery)
+			// nothing is fetched from GitHub to generate a review
+			assert.Empty(t, result.Missing, result.Delivery)
+		}
+	})
+
+	t.Run("should handle redeliveries of deliveries that failed", func(t *testing.T) {
+		c := *c
+		c.Review.OptIn = false
+		// nothing is recorded for GitHub so getting the diff fails
+		r := pullRequestRecording(t, "4", "opened", "Adds a line")
+
+		results, err := replayInProcess(&c, replayAIFake, []*recording{r, r})
+
+		assert.NoError(t, err)
+		assert.Contains(t, results[0].Missing, "GET /repos/owner/repo/pulls/1.diff")
+		assert.Contains(t, results[1].Missing, "GET /repos/owner/repo/pulls/1.diff")
+	})
+
+	t.Run("should count deliveries by what happened", func(t *testing.T) {
+		c := *c
+		c.Review.OptIn = false
+		failed := webhookCount(t, "opened", "error")
+		skipped := webhookCount(t, "closed", "skipped")
+		duplicates := webhookCount(t, "closed", "duplicate")
+
+		// the review fails since nothing is recorded for GitHub
+		failing := pullRequestRecording(t, "6", "opened", "Adds a line")
+		// nothing is done for closed pull requests
+		closed := pullRequestRecording(t, "7", "closed", "Adds a line")
+		_, err := replayInProcess(&c, replayAIFake, []*recording{failing, closed, closed})
+
+		assert.NoError(t, err)
+		assert.Equal(t, failed+1, webhookCount(t, "opened", "error"))
+		assert.Equal(t, skipped+1, webhookCount(t, "closed", "skipped"))
+		assert.Equal(t, duplicates+1, webhookCount(t, "closed", "duplicate"))
+	})
+
+	t.Run("should not handle pull requests that are already being worked on", func(t *testing.T) {
+		c := *c
+		c.Review.OptIn = false
+
+		fake := &fakeGithub{responses: map[string]json.RawMessage{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		gh := github.NewClient(nil)
+		gh.BaseURL, _ = url.Parse(server.URL + "/")
+
+		runs := store.NewMemoryStore()
+		webhookConfig, err := newWebhookConfig(&c, gh, runs)
+		assert.NoError(t, err)
+		provider := &replayProvider{}
+		locks := nit.NewPullRequestLocks()
+		handler := HandleGithubEvents(&c, webhookConfig, nit.NewAI(provider, provider), gh, runs, locks)
+
+		duplicates := webhookCount(t, "opened", "duplicate")
+
+		// a review of the same changes is in progress
+		assert.True(t, locks.TryLock("owner", "repo", 1, "abc123"))
+		fake.reset(nil)
+		req, err := pullRequestRecording(t, "5", "opened", "Adds a line").request("/webhooks/github", "secret")
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, fake.writes)
+		assert.Empty(t, fake.missing)
+		assert.Equal(t, duplicates+1, webhookCount(t, "opened", "duplicate"))
+	})
+
+	t.Run("should acknowledge deliveries before handling them", func(t *testing.T) {
+		c := *c
+		c.Review.OptIn = false
+
+		fake := &fakeGithub{responses: map[string]json.RawMessage{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		gh := github.NewClient(nil)
+		gh.BaseURL, _ = url.Parse(server.URL + "/")
+
+		runs := store.NewMemoryStore()
+		webhookConfig, err := newWebhookConfig(&c, gh, runs)
+		assert.NoError(t, err)
+
+		// whether the response was sent by the time the review is generated
+		rec := httptest.NewRecorder()
+		acknowledged := false
+		provider := providerFunc(func(req *nit.CompletionRequest) (*nit.CompletionResponse, error) {
+			acknowledged = rec.Flushed
+			return &nit.CompletionResponse{Completion: "{}"}, nil
+		})
+		handler := HandleGithubEvents(&c, webhookConfig, nit.NewAI(provider, provider), gh, runs, nit.NewPullRequestLocks())
+
+		fake.reset(map[string]json.RawMessage{
+			"GET /repos/owner/repo/pulls/1.diff": json.RawMessage(`"diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1 +1,2 @@\n line 1\n+line 2"`),
+		})
+		req, err := pullRequestRecording(t, "8", "opened", "Adds a line").request("/webhooks/github", "secret")
+		assert.NoError(t, err)
+		handler(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.True(t, acknowledged)
+	})
+}
+
+func TestNewWebhookConfig(t *testing.T) {
+	t.Run("should not accept invalid repository policies", func(t *testing.T) {
+		c := &config.Config{
+			Review: config.ReviewConfig{
+				Repos: map[string]config.RepoConfig{"owner/repo": {Critique: "always"}},
+			},
+		}
+		gh := github.NewClient(ghMock.NewMockedHTTPClient())
+
+		_, err := newWebhookConfig(c, gh, store.NewMemoryStore())
+
+		assert.ErrorContains(t, err, `owner/repo: unknown critique "always"`)
+	})
+}
+
+func TestReviewPullRequest(t *testing.T) {
+	event := &github.PullRequestEvent{
+		Action: github.String("opened"),
+		PullRequest: &github.PullRequest{
+			Number: github.Int(1),
+			Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+		},
+		Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("owner")},
+		},
+	}
+
+	t.Run("should record failed runs with the tokens spent", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte("diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1 +1,2 @@\n line 1\n+line 2"))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsReviewsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					ghMock.WriteError(w, http.StatusInternalServerError, "github went belly up or something")
+				}),
+			),
+		))
+		provider := &staticProvider{completion: `{"body": "Adds a line.", "event": "COMMENT", "comments": []}`}
+		runs := store.NewMemoryStore()
+
+		_, err := reviewPullRequest(context.Background(), event, &nit.Config{}, nit.NewAI(provider, provider), mockGithub, runs)
+
+		assert.Error(t, err)
+		saved, err := runs.ListRuns(store.RunFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, saved, 1)
+		assert.Equal(t, store.OutcomeFailed, saved[0].Outcome)
+		assert.Equal(t, 20, saved[0].Tokens)
+		assert.Equal(t, "gpt-4o", saved[0].Model)
+	})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/evanmcneely/nit"
+	"github.com/evanmcneely/nit/internal/config"
+	"github.com/evanmcneely/nit/internal/store"
+	"github.com/google/go-github/v59/github"
+)
+
+const replayUsage = `Replay recorded webhook deliveries.
+
+Usage:
+  server replay [flags] file...
+
+Each file is a JSON recording of a delivery:
+
+  {
+    "headers": {"X-GitHub-Event": "pull_request", "X-GitHub-Delivery": "..."},
+    "payload": {...},
+    "github": {"GET /repos/owner/repo/pulls/1.diff": "diff --git ...", ...},
+    "completions": ["...", ...]
+  }
+
+The payload is signed with the webhook secret and posted to -url, or handled in-process
+against a stand-in for GitHub that responds to GET requests from "github" (".diff" is
+added to the path when the diff is requested) and records everything else. The stand-in
+AI provider responds with "completions" in order. What would have been posted to GitHub
+is reported for each delivery.
+
+Flags:
+`
+
+// How completions are created when replaying in-process
+const (
+	// Completions come from the recording
+	replayAIFake = "fake"
+	// Completions are created by the configured AI provider
+	replayAILive = "live"
+)
+
+// A webhook delivery saved to replay
+type recording struct {
+	Headers map[string]string `json:"headers"`
+	Payload json.RawMessage   `json:"payload"`
+	// Responses to GitHub API requests keyed by method and path, strings are written as
+	// is and anything else as JSON
+	Github map[string]json.RawMessage `json:"github"`
+	// Responses to completion requests, in order
+	Completions []string `json:"completions"`
+}
+
+// What happened when a recording was replayed
+type replayResult struct {
+	File     string `json:"file"`
+	Delivery string `json:"delivery"`
+	Event    string `json:"event"`
+	Status   int    `json:"status"`
+	// The requests that would have changed something on GitHub
+	Writes []*githubWrite `json:"writes"`
+	// The GET requests the recording has no response for
+	Missing []string `json:"missing,omitempty"`
+}
+
+// A request that would have changed something on GitHub
+type githubWrite struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Run the replay command with the arguments after "replay"
+func runReplay(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), replayUsage)
+		flags.PrintDefaults()
+	}
+	target := flags.String("url", "", "post the deliveries to the webhook URL of a running server instead of handling them in-process")
+	secret := flags.String("secret", "", "sign the deliveries with this secret instead of the configured one")
+	aiMode := flags.String("ai", replayAIFake, "create completions with the recorded responses (fake) or the configured provider (live)")
+	asJSON := flags.Bool("json", false, "report the results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no recordings to replay")
+	}
+	if *aiMode != replayAIFake && *aiMode != replayAILive {
+		return fmt.Errorf("unknown ai %q", *aiMode)
+	}
+
+	c, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *secret != "" {
+		c.App.WebhookSecret = *secret
+	}
+
+	// Logs go to stderr so they don't get mixed up with the report
+	logger, err := newLogger(c.Log)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	recordings := make([]*recording, flags.NArg())
+	for i, file := range flags.Args() {
+		if recordings[i], err = loadRecording(file); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	var results []*replayResult
+	if *target != "" {
+		results, err = replayRemote(http.DefaultClient, *target, c.App.WebhookSecret, recordings)
+	} else {
+		results, err = replayInProcess(&c, *aiMode, recordings)
+	}
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		result.File = flags.Arg(i)
+	}
+
+	if *asJSON {
+		payload, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(payload))
+		return err
+	}
+	return printReplay(stdout, results)
+}
+
+func loadRecording(path string) (*recording, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r recording
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Payload) == 0 {
+		return nil, errors.New("no payload")
+	}
+	if r.header(github.EventTypeHeader) == "" {
+		return nil, fmt.Errorf("no %s header", github.EventTypeHeader)
+	}
+	return &r, nil
+}
+
+// Get a header of the recording regardless of how it was capitalized
+func (r *recording) header(name string) string {
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Build the request GitHub would have sent, signed with the secret
+func (r *recording) request(target, secret string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(r.Payload))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range r.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Recorded signatures were made with a secret that may not be the configured one
+	req.Header.Del(github.SHA1SignatureHeader)
+	req.Header.Del(github.SHA256SignatureHeader)
+	if secret != "" {
+		req.Header.Set(github.SHA256SignatureHeader, signPayload(secret, r.Payload))
+	}
+	return req, nil
+}
+
+// Sign a payload the way GitHub signs webhook deliveries
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post each recording to a running server. Only the status is known, run the server with
+// dry runs on to see what it would have posted.
+func replayRemote(client *http.Client, target, secret string, recordings []*recording) ([]*replayResult, error) {
+	results := []*replayResult{}
+	for _, r := range recordings {
+		req, err := r.request(target, secret)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		results = append(results, &replayResult{
+			Delivery: r.header(github.DeliveryIDHeader),
+			Event:    r.header(github.EventTypeHeader),
+			Status:   resp.StatusCode,
+		})
+	}
+	return results, nil
+}
+
+// Handle each recording with the webhook handler, against stand-ins for GitHub and (unless
+// the AI is live) the AI provider. Recordings are handled in order by the same handler, so
+// a redelivery is skipped like it would be by the server.
+func replayInProcess(c *config.Config, aiMode string, recordings []*recording) ([]*replayResult, error) {
+	fake := &fakeGithub{responses: map[string]json.RawMessage{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	gh := github.NewClient(nil)
+	gh.BaseURL, _ = url.Parse(server.URL + "/")
+
+	provider := &replayProvider{}
+	var ai *nit.AI
+	if aiMode == replayAILive {
+		openai := nit.NewOpenAI(c.App.OpenaiKey)
+		ai = nit.NewAI(openai, openai)
+	} else {
+		ai = nit.NewAI(provider, provider)
+	}
+	ai.RedactContent = c.Log.Redact
+
+	// The authenticated user is looked up once, with the responses of the first recording
+	fake.reset(recordings[0].Github)
+	runs := store.NewMemoryStore()
+	webhookConfig, err := newWebhookConfig(c, gh, runs)
+	if err != nil {
+		return nil, err
+	}
+	handler := HandleGithubEvents(c, webhookConfig, ai, gh, runs, nit.NewPullRequestLocks())
+
+	results := []*replayResult{}
+	for _, r := range recordings {
+		fake.reset(r.Github)
+		provider.reset(r.Completions)
+
+		req, err := r.request("/webhooks/github", c.App.WebhookSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		// Deliveries are handled before the handler returns
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		results = append(results, &replayResult{
+			Delivery: r.header(github.DeliveryIDHeader),
+			Event:    r.header(github.EventTypeHeader),
+			Status:   rec.Code,
+			Writes:   fake.writes,
+			Missing:  fake.missing,
+		})
+	}
+	return results, nil
+}
+
+// A stand-in for the GitHub API. GET requests (and GraphQL queries) are responded to from
+// the recorded responses, everything else is recorded and responded to with an empty
+// object.
+type fakeGithub struct {
+	mu        sync.Mutex
+	responses map[string]json.RawMessage
+	writes    []*githubWrite
+	missing   []string
+}
+
+// Respond with the responses of the next recording and forget the requests of the last one
+func (f *fakeGithub) reset(responses map[string]json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = map[string]json.RawMessage{}
+	for key, response := range responses {
+		f.responses[key] = response
+	}
+	f.writes = []*githubWrite{}
+	f.missing = nil
+}
+
+func (f *fakeGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	key := r.Method + " " + r.URL.Path
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "diff") {
+		key += ".diff"
+	}
+
+	if r.Method != http.MethodGet && !isGraphQLQuery(r.URL.Path, body) {
+		f.writes = append(f.writes, &githubWrite{Method: r.Method, Path: r.URL.Path, Body: body})
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte("{}"))
+		return
+	}
+
+	response, ok := f.responses[key]
+	if !ok {
+		f.missing = append(f.missing, key)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Not Found"}`))
+		return
+	}
+
+	// Diffs and other raw responses are recorded as strings
+	var raw string
+	if err := json.Unmarshal(response, &raw); err == nil {
+		w.Write([]byte(raw))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Whether a request is a GraphQL query, which only reads, rather than a mutation
+func isGraphQLQuery(path string, body []byte) bool {
+	if !strings.HasSuffix(path, "/graphql") {
+		return false
+	}
+	var req struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(body, &req)
+	return !strings.HasPrefix(strings.TrimSpace(req.Query), "mutation")
+}
+
+// A stand-in AI provider that responds with recorded completions in order. Once they run
+// out it responds with an empty object, which parses as an empty review.
+type replayProvider struct {
+	mu          sync.Mutex
+	completions []string
+}
+
+func (p *replayProvider) reset(completions []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completions = completions
+}
+
+func (p *replayProvider) CreateCompletetion(req *nit.CompletionRequest) (*nit.CompletionResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	completion := "{}"
+	if len(p.completions) > 0 {
+		completion, p.completions = p.completions[0], p.completions[1:]
+	}
+	return &nit.CompletionResponse{Completion: completion, Model: "replay"}, nil
+}
+
+var reviewPath = regexp.MustCompile(`^/repos/[^/]+/[^/]+/pulls/\d+/reviews$`)
+
+// Print each result with what would have been posted to GitHub. Reviews are rendered the
+// way they would look on GitHub and other requests are printed as JSON.
+func printReplay(w io.Writer, results []*replayResult) error {
+	var sb strings.Builder
+	for _, result := range results {
+		sb.WriteString(fmt.Sprintf("# %s: %s delivery %s responded %d\n", result.File, result.Event, result.Delivery, result.Status))
+
+		for _, write := range result.Writes {
+			sb.WriteString(fmt.Sprintf("\n## %s %s\n\n", write.Method, write.Path))
+
+			var review github.PullRequestReviewRequest
+			if write.Method == http.MethodPost && reviewPath.MatchString(write.Path) && json.Unmarshal(write.Body, &review) == nil {
+				sb.WriteString(nit.FormatReviewMarkdown(&review))
+				continue
+			}
+
+			var body bytes.Buffer
+			if err := json.Indent(&body, write.Body, "", "  "); err != nil {
+				body.Reset()
+				body.Write(write.Body)
+			}
+			sb.WriteString("```json\n" + body.String() + "\n```\n")
+		}
+
+		if len(result.Missing) > 0 {
+			sb.WriteString("\n## No recorded response for\n\n")
+			for _, key := range result.Missing {
+				sb.WriteString("- " + key + "\n")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := fmt.Fprint(w, sb.String())
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evanmcneely/nit/internal/config"
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayInProcess(t *testing.T) {
+	c := &config.Config{
+		App: config.AppConfig{WebhookSecret: "secret"},
+		Review: config.ReviewConfig{
+			Name:       "nit",
+			RepoConfig: config.RepoConfig{Describe: "off"},
+		},
+	}
+
+	t.Run("should report the review that would have been posted", func(t *testing.T) {
+		r, err := loadRecording("testdata/pull_request_opened.json")
+		assert.NoError(t, err)
+
+		results, err := replayInProcess(c, replayAIFake, []*recording{r})
+
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, http.StatusNoContent, results[0].Status)
+		assert.Equal(t, "pull_request", results[0].Event)
+		assert.Len(t, results[0].Writes, 1)
+		assert.Equal(t, http.MethodPost, results[0].Writes[0].Method)
+		assert.Equal(t, "/repos/owner/repo/pulls/1/reviews", results[0].Writes[0].Path)
+		assert.Contains(t, string(results[0].Writes[0].Body), "End this line with a period.")
+		assert.Contains(t, results[0].Missing, "GET /repos/owner/repo/contents/file.txt")
+	})
+
+	t.Run("should skip redeliveries", func(t *testing.T) {
+		r, err := loadRecording("testdata/pull_request_opened.json")
+		assert.NoError(t, err)
+
+		results, err := replayInProcess(c, replayAIFake, []*recording{r, r})
+
+		assert.NoError(t, err)
+		assert.Len(t, results[0].Writes, 1)
+		assert.Empty(t, results[1].Writes)
+	})
+
+	t.Run("should print the review as markdown", func(t *testing.T) {
+		r, err := loadRecording("testdata/pull_request_opened.json")
+		assert.NoError(t, err)
+		results, err := replayInProcess(c, replayAIFake, []*recording{r})
+		assert.NoError(t, err)
+
+		var out strings.Builder
+		assert.NoError(t, printReplay(&out, results))
+
+		assert.Contains(t, out.String(), "## POST /repos/owner/repo/pulls/1/reviews\n\n**Event:** COMMENT\n\nAdds a line.\n")
+		assert.Contains(t, out.String(), "- GET /repos/owner/repo/contents/file.txt\n")
+	})
+}
+
+func TestReplayRemote(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := github.ValidatePayload(r, []byte("secret")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	r, err := loadRecording("testdata/pull_request_opened.json")
+	assert.NoError(t, err)
+
+	t.Run("should sign the recording with the secret", func(t *testing.T) {
+		results, err := replayRemote(server.Client(), server.URL, "secret", []*recording{r})
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, results[0].Status)
+		assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", results[0].Delivery)
+	})
+
+	t.Run("should not send the recorded signature", func(t *testing.T) {
+		results, err := replayRemote(server.Client(), server.URL, "other", []*recording{r})
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, results[0].Status)
+	})
+}
+
+func TestFakeGithub(t *testing.T) {
+	t.Run("should only respond with the responses of the last reset", func(t *testing.T) {
+		fake := &fakeGithub{responses: map[string]json.RawMessage{}}
+		fake.reset(map[string]json.RawMessage{"GET /first": json.RawMessage(`{}`)})
+		fake.reset(map[string]json.RawMessage{"GET /second": json.RawMessage(`{}`)})
+
+		first := httptest.NewRecorder()
+		fake.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/first", nil))
+		second := httptest.NewRecorder()
+		fake.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/second", nil))
+
+		assert.Equal(t, http.StatusNotFound, first.Code)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, []string{"GET /first"}, fake.missing)
+	})
+}
@@ -13,9 +13,11 @@ const noreply = "noreply"
 type CommentResponse struct {
 	Tokens int
 	Id     int64
+	// The reply that would have been posted when it was a dry run
+	DryRun *DryRun
 }
 
-func ShouldRespondToComment(e *github.PullRequestReviewCommentEvent, client *github.Client, config *Config) (bool, string) {
+func ShouldRespondToComment(ctx context.Context, e *github.PullRequestReviewCommentEvent, client *github.Client, config *Config) (bool, string) {
 	var (
 		action     = e.GetAction()
 		author     = e.GetComment().GetUser().GetLogin()
@@ -37,8 +39,11 @@ func ShouldRespondToComment(e *github.PullRequestReviewCommentEvent, client *git
 		return false, "comment was not \"created\""
 	}
 
-	// TODO: handle rate limiting
-	origComment, _, err := client.PullRequests.GetComment(context.Background(), owner, repository, inReplyTo)
+	var origComment *github.PullRequestComment
+	err := retryRateLimit(func() (err error) {
+		origComment, _, err = client.PullRequests.GetComment(ctx, owner, repository, inReplyTo)
+		return err
+	})
 	if err != nil {
 		return false, fmt.Sprintf("could not retrieve original comment: %v", err)
 	}
@@ -51,7 +56,7 @@ func ShouldRespondToComment(e *github.PullRequestReviewCommentEvent, client *git
 	return true, ""
 }
 
-func RespondToComment(event *github.PullRequestReviewCommentEvent, config *Config, ai *AI, gh *github.Client) (*CommentResponse, error) {
+func RespondToComment(ctx context.Context, event *github.PullRequestReviewCommentEvent, config *Config, ai *AI, gh *github.Client) (*CommentResponse, error) {
 	var (
 		owner      = event.GetRepo().GetOwner().GetLogin()
 		repository = event.GetRepo().GetName()
@@ -59,67 +64,79 @@ func RespondToComment(event *github.PullRequestReviewCommentEvent, config *Confi
 		body       = event.GetComment().GetBody()
 		hunk       = event.GetComment().GetDiffHunk()
 		inReplyTo  = event.GetComment().GetInReplyTo()
+		nodeID     = event.GetComment().GetNodeID()
+		id         = event.GetComment().GetID()
+		headSHA    = event.GetPullRequest().GetHead().GetSHA()
 	)
 
-	comments, err := getCommentsOnHunk(owner, repository, hunk, pr, gh)
+	thread, err := getReviewThread(ctx, owner, repository, nodeID, id, gh)
 	if err != nil {
 		return nil, err
 	}
 
+	// The head version of the file lets the reply suggest a change to the lines of the
+	// thread. This is best effort, the reply is made without it if the file can't be retrieved.
+	var lines []string
+	start, end := thread.lines()
+	if end != 0 && headSHA != "" {
+		if content, err := getFileContent(ctx, owner, repository, headSHA, thread.Path, gh); err == nil {
+			lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		}
+	}
+
 	reply, err := ai.GenerateCommentReply(
+		ctx,
 		body,
 		hunk,
-		comments,
+		formatCommentedCode(thread.Path, lines, start, end),
+		thread.pullRequestComments(),
 		config.AppName,
 	)
-	if err != nil || reply == nil {
-		return &CommentResponse{Tokens: reply.Tokens}, err
+	if err != nil {
+		return nil, err
 	}
 	if reply.Completion == noreply {
+		ai.Logger().Info("not replying to comment", "stage", stageReply, "reason", noreply, "tokens", reply.Tokens)
 		return &CommentResponse{Tokens: reply.Tokens}, nil
 	}
 
-	comment, _, err := gh.PullRequests.CreateCommentInReplyTo(
-		context.Background(),
-		owner,
-		repository,
-		pr,
-		reply.Completion,
-		inReplyTo,
-	)
+	replyBody := applyReplySuggestion(reply.Completion, lines, start, end)
+	if isDryRun(config, owner, repository, event.GetPullRequest().GetBody()) {
+		run := &DryRun{
+			Owner:     owner,
+			Repo:      repository,
+			Number:    pr,
+			HeadSHA:   headSHA,
+			Model:     reply.Model,
+			Tokens:    reply.Tokens,
+			Reply:     replyBody,
+			InReplyTo: inReplyTo,
+		}
+		if err := writeDryRun(config, ai, stageReply, run); err != nil {
+			return &CommentResponse{Tokens: reply.Tokens}, err
+		}
+		return &CommentResponse{Tokens: reply.Tokens, DryRun: run}, nil
+	}
+
+	var comment *github.PullRequestComment
+	err = retryRateLimit(func() (err error) {
+		comment, _, err = gh.PullRequests.CreateCommentInReplyTo(
+			ctx,
+			owner,
+			repository,
+			pr,
+			replyBody,
+			inReplyTo,
+		)
+		return err
+	})
 	if err != nil {
 		return &CommentResponse{Tokens: reply.Tokens}, err
 	}
+	ai.Logger().Info("replied to comment", "stage", stageReply, "comment_id", comment.GetID(), "tokens", reply.Tokens)
 
 	return &CommentResponse{
 		Tokens: reply.Tokens,
 		Id:     comment.GetID(),
 	}, nil
 }
-
-func getCommentsOnHunk(owner, repo, hunk string, number int, gh *github.Client) ([]*github.PullRequestComment, error) {
-	// todo: paginate this to get all comments
-	allComments, _, err := gh.PullRequests.ListComments(
-		context.Background(),
-		owner,
-		repo,
-		number,
-		&github.PullRequestListCommentsOptions{},
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	filterComments := []*github.PullRequestComment{}
-	for _, comment := range allComments {
-		// Use the current diff hunk that the comment pertains to to filter out comments
-		// not apart of the current thread. This is inefficient because hunks can be very
-		// large strings. Works for now.
-		if comment.DiffHunk != nil && *comment.DiffHunk != hunk {
-			continue
-		}
-		filterComments = append(filterComments, comment)
-	}
-
-	return filterComments, nil
-}
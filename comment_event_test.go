@@ -1,6 +1,7 @@
 package nit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -48,7 +49,7 @@ func TestShouldRespondToComment(t *testing.T) {
 			},
 		}
 
-		ok, _ := ShouldRespondToComment(event, mockGh, &Config{AppName: appName})
+		ok, _ := ShouldRespondToComment(context.Background(), event, mockGh, &Config{AppName: appName})
 		assert.False(t, ok)
 	})
 
@@ -80,7 +81,7 @@ func TestShouldRespondToComment(t *testing.T) {
 
 		for _, action := range ignoredActions {
 			event := getIgonredEvent(action)
-			ok, _ := ShouldRespondToComment(event, mockGh, &Config{AppName: appName})
+			ok, _ := ShouldRespondToComment(context.Background(), event, mockGh, &Config{AppName: appName})
 			assert.False(t, ok)
 		}
 	})
@@ -104,7 +105,7 @@ func TestShouldRespondToComment(t *testing.T) {
 			},
 		}
 
-		ok, _ := ShouldRespondToComment(event, mockGh, &Config{AppName: appName})
+		ok, _ := ShouldRespondToComment(context.Background(), event, mockGh, &Config{AppName: appName})
 		assert.False(t, ok)
 	})
 
@@ -127,7 +128,7 @@ func TestShouldRespondToComment(t *testing.T) {
 			},
 		}
 
-		ok, _ := ShouldRespondToComment(event, mockGh, &Config{AppName: appName})
+		ok, _ := ShouldRespondToComment(context.Background(), event, mockGh, &Config{AppName: appName})
 		assert.False(t, ok)
 	})
 }
@@ -140,6 +141,7 @@ func TestRespondToComment(t *testing.T) {
 		commentId int64 = 456
 		comment         = "bla bla bla"
 		hunk            = "hunky"
+		nodeId          = "PRRC_1"
 		reply           = "wa wa wa"
 		comments        = []*github.PullRequestComment{
 			{
@@ -150,6 +152,11 @@ func TestRespondToComment(t *testing.T) {
 				Body:     github.String(comment),
 			},
 		}
+		// the review thread of the comment returned from the GraphQL API
+		thread = fmt.Sprintf(`{"data": {"node": {"pullRequestReviewThread":
+			{"id": "PRRT_2", "comments": {"nodes": [{"id": %q, "body": %q, "diffHunk": %q, "author": {"login": "user"}}]}}
+		}}}`, nodeId, comment, hunk)
+		graphqlEndpoint = ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"}
 	)
 
 	t.Run("should reply to a comment in thread", func(t *testing.T) {
@@ -163,10 +170,12 @@ func TestRespondToComment(t *testing.T) {
 
 		var commentPayload *github.PullRequestComment
 		mockedHTTPClient := ghMock.NewMockedHTTPClient(
-			ghMock.WithRequestMatch(
+			ghMock.WithRequestMatchHandler(
 				// request to get all the comments in the thread returns our comment
-				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
-				comments,
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(thread))
+				}),
 			),
 			ghMock.WithRequestMatchHandler(
 				// request to post the comment reply successful
@@ -196,13 +205,14 @@ func TestRespondToComment(t *testing.T) {
 					Login: github.String(user),
 				},
 				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
 				DiffHunk:  github.String(hunk),
 				Body:      github.String(comment),
 			},
 		}
 
 		// should return no errors
-		_, ok := RespondToComment(event, &Config{AppName: appName}, mockAI, mockGithub)
+		_, ok := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
 		assert.Nil(t, ok)
 
 		// assert that the payload "sent" to Github was formed properly
@@ -214,13 +224,83 @@ func TestRespondToComment(t *testing.T) {
 		// assert that the call to generate reply is formed correctly
 		gotAI := mockProvider.calls.CreateCompletetion[0].Req
 		wantAI := &CompletionRequest{
-			Prompt: fmt.Sprintf(commentReplyPrompt, comment, hunk, appName, formatPullRequestComments(comments)),
+			Prompt: fmt.Sprintf(commentReplyPrompt, comment, hunk, "", appName, formatPullRequestComments(comments)),
 			Model:  modelGood,
 			Format: formatText,
 		}
 		assert.Equal(t, wantAI, gotAI)
 	})
 
+	t.Run("should reply with a suggested change to the lines of the thread", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "Like this:\n\n```original\nline 2\n```\n```suggestion\nbetter line 2\n```", Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		var commentPayload *github.PullRequestComment
+		mockedHTTPClient := ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				// the thread is on line 2 of the file
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(fmt.Sprintf(`{"data": {"node": {"pullRequestReviewThread":
+						{"id": "PRRT_1", "path": "file.txt", "line": 2, "comments": {"nodes": [{"id": %q, "body": %q, "author": {"login": "user"}}]}}
+					}}}`, nodeId, comment)))
+				}),
+			),
+			ghMock.WithRequestMatch(
+				// return the head version of the file
+				ghMock.GetReposContentsByOwnerByRepoByPath,
+				github.RepositoryContent{
+					Type:    github.String("file"),
+					Content: github.String("line 1\nline 2\nline 3\n"),
+				},
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&commentPayload)
+					w.Write([]byte(""))
+				}),
+			),
+		)
+		mockGithub := github.NewClient(mockedHTTPClient)
+
+		event := &github.PullRequestReviewCommentEvent{
+			Action: github.String("created"),
+			PullRequest: &github.PullRequest{
+				Number: github.Int(pr),
+				Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+			},
+			Repo: &github.Repository{
+				Name: github.String("repo"),
+				Owner: &github.User{
+					Login: github.String("user"),
+				},
+			},
+			Comment: &github.PullRequestComment{
+				User: &github.User{
+					Login: github.String(user),
+				},
+				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
+				DiffHunk:  github.String(hunk),
+				Body:      github.String("how would you fix that?"),
+			},
+		}
+
+		_, err := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Like this:\n\n```suggestion\nbetter line 2\n```", commentPayload.GetBody())
+
+		// the head version of the file is given to the AI
+		code := formatCommentedCode("file.txt", []string{"line 1", "line 2", "line 3"}, 2, 2)
+		assert.Contains(t, mockProvider.calls.CreateCompletetion[0].Req.Prompt, code)
+	})
+
 	t.Run("should not post a reply if the model doesn't want to", func(t *testing.T) {
 		mockProvider := AIProviderMock{
 			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
@@ -231,10 +311,12 @@ func TestRespondToComment(t *testing.T) {
 		mockAI := NewAI(&mockProvider, &mockProvider)
 
 		mockedHTTPClient := ghMock.NewMockedHTTPClient(
-			ghMock.WithRequestMatch(
+			ghMock.WithRequestMatchHandler(
 				// request to get all the comments in the thread returns our comments
-				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
-				comments,
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(thread))
+				}),
 			),
 		)
 		mockGithub := github.NewClient(mockedHTTPClient)
@@ -256,18 +338,28 @@ func TestRespondToComment(t *testing.T) {
 					Login: github.String(user),
 				},
 				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
 				DiffHunk:  github.String(hunk),
 				Body:      github.String(comment),
 			},
 		}
 
 		// should return no errors
-		_, ok := RespondToComment(event, &Config{AppName: appName}, mockAI, mockGithub)
+		_, ok := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
 		assert.Nil(t, ok)
 	})
 
 	t.Run("should return AI provider errors when generating reply fails", func(t *testing.T) {
-		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient())
+		mockedHTTPClient := ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				// request to get all the comments in the thread returns our comment
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(thread))
+				}),
+			),
+		)
+		mockGithub := github.NewClient(mockedHTTPClient)
 		mockProvider := AIProviderMock{
 			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
 				return nil, errors.New("something happened")
@@ -292,20 +384,23 @@ func TestRespondToComment(t *testing.T) {
 					Login: github.String(user),
 				},
 				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
 				DiffHunk:  github.String(hunk),
 				Body:      github.String(comment),
 			},
 		}
 
-		_, ok := RespondToComment(event, &Config{AppName: appName}, mockAI, mockGithub)
+		resp, ok := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
 		assert.Error(t, ok)
+		assert.Nil(t, resp)
+		assert.Len(t, mockProvider.calls.CreateCompletetion, 1)
 	})
 
 	t.Run("should return Github errors when fetching the comment thread fails", func(t *testing.T) {
 		mockedHTTPClient := ghMock.NewMockedHTTPClient(
 			ghMock.WithRequestMatchHandler(
 				// request to get comment thread fails
-				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
+				graphqlEndpoint,
 				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					ghMock.WriteError(
 						w,
@@ -338,21 +433,24 @@ func TestRespondToComment(t *testing.T) {
 					Login: github.String(user),
 				},
 				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
 				DiffHunk:  github.String(hunk),
 				Body:      github.String(comment),
 			},
 		}
 
-		_, ok := RespondToComment(event, &Config{AppName: appName}, mockAI, mockGithub)
+		_, ok := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
 
 	t.Run("should return Github errors when posting the comment fails", func(t *testing.T) {
 		mockedHTTPClient := ghMock.NewMockedHTTPClient(
-			ghMock.WithRequestMatch(
+			ghMock.WithRequestMatchHandler(
 				// fetching the comment thread is successful
-				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
-				comments,
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(thread))
+				}),
 			),
 			ghMock.WithRequestMatchHandler(
 				// posting the comment fails
@@ -396,12 +494,70 @@ func TestRespondToComment(t *testing.T) {
 					Login: github.String(user),
 				},
 				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
 				DiffHunk:  github.String(hunk),
 				Body:      github.String(comment),
 			},
 		}
 
-		_, ok := RespondToComment(event, &Config{AppName: appName}, mockAI, mockGithub)
+		_, ok := RespondToComment(context.Background(), event, &Config{AppName: appName}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
+
+	t.Run("should write the reply instead of posting it when the pull request asks for a dry run", func(t *testing.T) {
+		mockedHTTPClient := ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				graphqlEndpoint,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(thread))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				// fail if the reply is posted
+				ghMock.PostReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the reply should not be posted")
+					ghMock.WriteError(w, http.StatusInternalServerError, "posted")
+				}),
+			),
+		)
+		mockGithub := github.NewClient(mockedHTTPClient)
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: reply, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		writer := &dryRunWriterMock{}
+
+		event := &github.PullRequestReviewCommentEvent{
+			Action: github.String("created"),
+			PullRequest: &github.PullRequest{
+				Number: github.Int(pr),
+				Body:   github.String("some changes\n\nai-review:dry-run"),
+			},
+			Repo: &github.Repository{
+				Name: github.String("repo"),
+				Owner: &github.User{
+					Login: github.String("user"),
+				},
+			},
+			Comment: &github.PullRequestComment{
+				User: &github.User{
+					Login: github.String(user),
+				},
+				InReplyTo: github.Int64(commentId),
+				NodeID:    github.String(nodeId),
+				DiffHunk:  github.String(hunk),
+				Body:      github.String(comment),
+			},
+		}
+
+		res, err := RespondToComment(context.Background(), event, &Config{AppName: appName, DryRunWriter: writer}, mockAI, mockGithub)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), res.Id)
+		assert.Len(t, writer.runs, 1)
+		assert.Equal(t, reply, writer.runs[0].Reply)
+		assert.Equal(t, commentId, writer.runs[0].InReplyTo)
+	})
 }
@@ -0,0 +1,123 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// The result of the self-critique pass over the comments of a review.
+type critiqueResult struct {
+	Tokens int
+	// The number of comments shown to the model
+	Critiqued int
+	// The number of comments the model decided to drop
+	Dropped int
+}
+
+// The verdict of the model on a single review comment.
+type critiqueVerdict struct {
+	Keep   bool   `json:"keep"`
+	Reason string `json:"reason"`
+}
+
+// Show each comment of the review to a model together with the diff hunk it was left on
+// and drop the ones that aren't correct, actionable and non-obvious. The model is the cheap
+// one unless CritiqueGood is given. Comments are kept when the verdict can't be parsed so
+// that a bad completion never loses a comment. The result is returned with errors so that
+// the tokens spent before the error are counted.
+func (ai *AI) critiqueComments(ctx context.Context, diff string, payload *github.PullRequestReviewRequest, model string) (*critiqueResult, error) {
+	diffFiles := parseDiffFiles(diff)
+	hunks := splitDiffHunks(diff)
+	result := &critiqueResult{}
+
+	kept := []*github.DraftReviewComment{}
+	for _, comment := range payload.Comments {
+		hunk := ""
+		if file := findChangedFile(diffFiles, comment.GetPath()); file != nil {
+			if i := file.hunkOf(comment); i >= 0 && i < len(hunks[file.Path]) {
+				hunk = hunks[file.Path][i]
+			}
+		}
+		message := fmt.Sprintf(critiquePrompt, comment.GetPath(), hunk, comment.GetBody())
+
+		c := ai.NewCompletion().Cheap()
+		if model == CritiqueGood {
+			c = ai.NewCompletion().Good()
+		}
+		resp, err := c.ReturnJSON().Create(ctx, message)
+		if err != nil {
+			return result, err
+		}
+		result.Tokens += resp.Tokens
+		result.Critiqued++
+
+		var verdict critiqueVerdict
+		err = json.Unmarshal([]byte(resp.Completion), &verdict)
+		if err != nil || verdict.Keep {
+			kept = append(kept, comment)
+			continue
+		}
+
+		result.Dropped++
+		ai.Logger().Info("dropping review comment", "stage", stageCritique, "path", comment.GetPath(), "reason", verdict.Reason)
+	}
+
+	payload.Comments = kept
+	return result, nil
+}
+
+// Get the index of the hunk a review comment is left on, -1 if it isn't in a hunk.
+func (f *changedFile) hunkOf(comment *github.DraftReviewComment) int {
+	if comment.Position != nil {
+		position := comment.GetPosition()
+		if position < 1 || position > len(f.Positions) {
+			return -1
+		}
+		return f.Positions[position-1].Hunk
+	}
+	for _, p := range f.Positions {
+		if p.Line != 0 && p.Line == comment.GetLine() {
+			return p.Hunk
+		}
+	}
+	return -1
+}
+
+// Split a git diff into the text of the hunks of each file (header included), keyed by the
+// path of the file after the change.
+func splitDiffHunks(diff string) map[string][]string {
+	hunks := map[string][]string{}
+
+	path := ""
+	var curr []string
+	flush := func() {
+		if path != "" && curr != nil {
+			hunks[path] = append(hunks[path], strings.Join(curr, "\n"))
+		}
+		curr = nil
+	}
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git"):
+			flush()
+			path = ""
+			if parts := strings.Fields(line); len(parts) > 3 {
+				path = strings.TrimPrefix(parts[3], "b/")
+			}
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			curr = []string{line}
+		case curr != nil:
+			curr = append(curr, line)
+		case strings.HasPrefix(line, "+++ b/"):
+			path = strings.TrimPrefix(line, "+++ b/")
+		}
+	}
+	flush()
+
+	return hunks
+}
@@ -0,0 +1,116 @@
+package nit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCritiqueComments(t *testing.T) {
+	const diff = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\n line 1\n+line 2\n line 3\n@@ -10,2 +11,3 @@\n line 11\n+line 12\n line 13"
+
+	createPayload := func() *github.PullRequestReviewRequest {
+		return &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("file.txt"), Position: github.Int(2), Body: github.String("first comment")},
+				{Path: github.String("file.txt"), Line: github.Int(12), Body: github.String("second comment")},
+			},
+		}
+	}
+
+	t.Run("should drop the comments the model rejects", func(t *testing.T) {
+		responses := []string{
+			`{"keep": false, "reason": "The line is fine."}`,
+			`{"keep": true, "reason": "The error is ignored."}`,
+		}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				resp := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: resp, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		payload := createPayload()
+
+		result, err := mockAI.critiqueComments(context.Background(), diff, payload, CritiqueCheap)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &critiqueResult{Tokens: 20, Critiqued: 2, Dropped: 1}, result)
+		assert.Len(t, payload.Comments, 1)
+		assert.Equal(t, "second comment", payload.Comments[0].GetBody())
+
+		calls := mockProvider.calls.CreateCompletetion
+		assert.Equal(t, modelCheap, calls[0].Req.Model)
+		assert.Equal(t, formatJSON, calls[0].Req.Format)
+		assert.Contains(t, calls[0].Req.Prompt, "@@ -1,2 +1,3 @@\n line 1\n+line 2\n line 3")
+		assert.NotContains(t, calls[0].Req.Prompt, "line 12")
+		assert.Contains(t, calls[1].Req.Prompt, "@@ -10,2 +11,3 @@\n line 11\n+line 12\n line 13")
+	})
+
+	t.Run("should keep comments when the verdict can't be parsed", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "not json", Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		payload := createPayload()
+
+		result, err := mockAI.critiqueComments(context.Background(), diff, payload, CritiqueGood)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, result.Dropped)
+		assert.Len(t, payload.Comments, 2)
+		assert.Equal(t, modelGood, mockProvider.calls.CreateCompletetion[0].Req.Model)
+	})
+
+	t.Run("should return an error when the provider fails", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return nil, errors.New("something happened")
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		_, err := mockAI.critiqueComments(context.Background(), diff, createPayload(), CritiqueCheap)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestGeneratePullRequestReviewCritique(t *testing.T) {
+	t.Run("should drop duplicates before critiquing and count both", func(t *testing.T) {
+		diff := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\n line 1\n+line 2\n line 3\n"
+		payload := `{"body": "body", "event": "COMMENT", "comments": [` +
+			`{"path": "file.txt", "position": 2, "body": "Handle the error returned here"},` +
+			`{"path": "file.txt", "position": 3, "body": "Rename this variable"}]}`
+		responses := []string{"notes", payload, `{"keep": false, "reason": "The name is fine."}`}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				resp := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: resp, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		details := &PullRequestDetails{
+			ExistingComments: []*github.PullRequestComment{
+				{Path: github.String("file.txt"), Line: github.Int(2), Body: github.String("Handle the error returned here")},
+			},
+		}
+
+		review, stats, err := mockAI.GeneratePullRequestReview(context.Background(), details, diff, "", RepoConfig{Critique: CritiqueCheap})
+
+		assert.NoError(t, err)
+		assert.Empty(t, review.Comments)
+		assert.Equal(t, 1, stats.Duplicates)
+		assert.Equal(t, 1, stats.Critiqued)
+		assert.Equal(t, 1, stats.Dropped)
+		// the duplicate was never critiqued
+		assert.Len(t, mockProvider.calls.CreateCompletetion, 3)
+	})
+}
@@ -0,0 +1,158 @@
+package nit
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	// Comments on lines this close together in the same file can be duplicates.
+	duplicateLineDistance = 3
+	// Comments with text at least this similar (0 to 1) are duplicates.
+	duplicateSimilarity = 0.6
+)
+
+var (
+	suggestionBlockRegex = regexp.MustCompile("(?s)```suggestion.*?```")
+	wordRegex            = regexp.MustCompile(`[a-z0-9_]+`)
+)
+
+// Get the review comments that have already been left on a pull request. This is best
+// effort, nothing is returned if the comments can't be retrieved.
+func getExistingReviewComments(ctx context.Context, owner, repo string, number, maxPages int, gh *github.Client) []*github.PullRequestComment {
+	comments, err := paginate(maxPages, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+		return gh.PullRequests.ListComments(
+			ctx,
+			owner,
+			repo,
+			number,
+			&github.PullRequestListCommentsOptions{ListOptions: *opts},
+		)
+	})
+	if err != nil {
+		return nil
+	}
+	return comments
+}
+
+// Remove the comments in the review payload that repeat what has already been said. A
+// comment is a duplicate when it is on a line near another comment in the same file with
+// similar text. Duplicates of existing comments on the pull request (by anyone) are
+// dropped. Duplicates of earlier comments in the payload are merged into the earlier
+// comment, which only gains the suggested change of the duplicate if it doesn't have one.
+// The comments in the payload are expected to have no badges yet, the badges of existing
+// comments are ignored. Returns the number of comments removed.
+func dedupeComments(diff string, payload *github.PullRequestReviewRequest, existing []*github.PullRequestComment) int {
+	diffFiles := parseDiffFiles(diff)
+
+	// The line in the head version of the file that a candidate comment is on
+	lineOf := func(comment *github.DraftReviewComment) int {
+		if comment.Position == nil {
+			return comment.GetLine()
+		}
+		file := findChangedFile(diffFiles, comment.GetPath())
+		if file == nil {
+			return 0
+		}
+		line, _, _ := file.positionsToLines(comment.GetPosition(), comment.GetPosition())
+		return line
+	}
+
+	kept := []*github.DraftReviewComment{}
+	keptLines := []int{}
+	removed := 0
+
+candidates:
+	for _, comment := range payload.Comments {
+		line := lineOf(comment)
+
+		for _, other := range existing {
+			otherLine := other.GetLine()
+			if otherLine == 0 {
+				otherLine = other.GetOriginalLine()
+			}
+			if isDuplicateComment(comment.GetPath(), line, comment.GetBody(), other.GetPath(), otherLine, stripBadge(other.GetBody())) {
+				removed++
+				continue candidates
+			}
+		}
+
+		for i, other := range kept {
+			if isDuplicateComment(comment.GetPath(), line, comment.GetBody(), other.GetPath(), keptLines[i], other.GetBody()) {
+				mergeSuggestion(other, comment)
+				removed++
+				continue candidates
+			}
+		}
+
+		kept = append(kept, comment)
+		keptLines = append(keptLines, line)
+	}
+
+	payload.Comments = kept
+	return removed
+}
+
+// Move the suggested change of a duplicate comment to the comment that is kept. Suggestions
+// replace the lines a comment is on so this only happens for comments on the same lines.
+func mergeSuggestion(kept, duplicate *github.DraftReviewComment) {
+	block := suggestionBlockRegex.FindString(duplicate.GetBody())
+	if block == "" || suggestionBlockRegex.MatchString(kept.GetBody()) {
+		return
+	}
+	if kept.GetPosition() != duplicate.GetPosition() ||
+		kept.GetStartLine() != duplicate.GetStartLine() ||
+		kept.GetLine() != duplicate.GetLine() {
+		return
+	}
+	kept.Body = github.String(kept.GetBody() + "\n\n" + block)
+}
+
+// Check if two comments say the same thing about the same place in the code. Comments on
+// unknown lines (0) are only compared by their text.
+func isDuplicateComment(pathA string, lineA int, bodyA string, pathB string, lineB int, bodyB string) bool {
+	if pathA != pathB {
+		return false
+	}
+	if lineA != 0 && lineB != 0 && abs(lineA-lineB) > duplicateLineDistance {
+		return false
+	}
+	return textSimilarity(bodyA, bodyB) >= duplicateSimilarity
+}
+
+// The Jaccard similarity of the words in two comments, from 0 (nothing in common) to 1
+// (the same words). Suggested changes are ignored.
+func textSimilarity(a, b string) float64 {
+	wordsA := commentWords(a)
+	wordsB := commentWords(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for word := range wordsA {
+		if wordsB[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wordsA)+len(wordsB)-shared)
+}
+
+func commentWords(body string) map[string]bool {
+	body = suggestionBlockRegex.ReplaceAllString(strings.ToLower(body), "")
+	words := map[string]bool{}
+	for _, word := range wordRegex.FindAllString(body, -1) {
+		words[word] = true
+	}
+	return words
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+package nit
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupeComments(t *testing.T) {
+	const diff = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +20,30 @@\nline 20\n+line 21\n+line 22\n+line 23\n+line 24\n+line 25\n+line 26\n+line 27\n+line 28\n+line 29\n+line 30"
+
+	comment := func(path string, position int, body string) *github.DraftReviewComment {
+		return &github.DraftReviewComment{
+			Path:     github.String(path),
+			Position: github.Int(position),
+			Body:     github.String(body),
+		}
+	}
+
+	t.Run("should drop comments that repeat existing comments on nearby lines", func(t *testing.T) {
+		payload := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				comment("file.txt", 2, "This error is not handled, consider returning it."),
+				comment("file.txt", 10, "This error is not handled, consider returning it."),
+				comment("other.txt", 2, "This error is not handled, consider returning it."),
+			},
+		}
+		existing := []*github.PullRequestComment{
+			{
+				Path: github.String("file.txt"),
+				Line: github.Int(22),
+				Body: github.String("The error is not handled here, consider returning it!"),
+			},
+		}
+
+		removed := dedupeComments(diff, payload, existing)
+
+		assert.Equal(t, 1, removed)
+		assert.Equal(t, []*github.DraftReviewComment{
+			comment("file.txt", 10, "This error is not handled, consider returning it."),
+			comment("other.txt", 2, "This error is not handled, consider returning it."),
+		}, payload.Comments)
+	})
+
+	t.Run("should use the original line of outdated existing comments", func(t *testing.T) {
+		payload := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				comment("file.txt", 2, "Use a constant for this magic number."),
+			},
+		}
+		existing := []*github.PullRequestComment{
+			{
+				Path:         github.String("file.txt"),
+				OriginalLine: github.Int(20),
+				Body:         github.String("Use a constant for this magic number."),
+			},
+		}
+
+		removed := dedupeComments(diff, payload, existing)
+
+		assert.Equal(t, 1, removed)
+		assert.Empty(t, payload.Comments)
+	})
+
+	t.Run("should ignore the badges of existing comments", func(t *testing.T) {
+		payload := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				comment("file.txt", 2, "Handle the error returned here."),
+			},
+		}
+		existing := []*github.PullRequestComment{
+			{
+				Path: github.String("file.txt"),
+				Line: github.Int(21),
+				Body: github.String("<!-- nit:severity=major -->\n**Major** · bug\n\nHandle the error returned here."),
+			},
+		}
+
+		removed := dedupeComments(diff, payload, existing)
+
+		assert.Equal(t, 1, removed)
+		assert.Empty(t, payload.Comments)
+	})
+
+	t.Run("should keep comments with different text on the same line", func(t *testing.T) {
+		payload := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				comment("file.txt", 2, "Use a constant for this magic number."),
+				comment("file.txt", 2, "This function is missing a test."),
+			},
+		}
+
+		removed := dedupeComments(diff, payload, nil)
+
+		assert.Equal(t, 0, removed)
+		assert.Len(t, payload.Comments, 2)
+	})
+
+	t.Run("should merge duplicate comments in the review", func(t *testing.T) {
+		payload := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				comment("file.txt", 2, "Use a constant for this magic number."),
+				comment("file.txt", 2, "Use a constant for the magic number.\n\n```suggestion\nline twenty one\n```"),
+				comment("file.txt", 3, "Use a constant for this magic number!"),
+			},
+		}
+
+		removed := dedupeComments(diff, payload, nil)
+
+		assert.Equal(t, 2, removed)
+		assert.Equal(t, []*github.DraftReviewComment{
+			comment("file.txt", 2, "Use a constant for this magic number.\n\n```suggestion\nline twenty one\n```"),
+		}, payload.Comments)
+	})
+}
+
+func TestTextSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, textSimilarity("Handle the error.", "handle THE error"))
+	assert.Equal(t, 0.0, textSimilarity("Handle the error.", "Add a test"))
+	assert.Equal(t, 1.0, textSimilarity("Handle the error.\n\n```suggestion\nreturn err\n```", "Handle the error."))
+	assert.Equal(t, 0.0, textSimilarity("", "Handle the error."))
+}
@@ -0,0 +1,122 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Records the IDs of the webhook deliveries that have been handled so that redeliveries
+// of the same event (GitHub retries deliveries and they can be redelivered by hand) are
+// only handled once. IDs are forgotten after the TTL, or with Forget when handling the
+// delivery fails so that a redelivery can try again.
+type DeliveryStore struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func NewDeliveryStore(ttl time.Duration) *DeliveryStore {
+	return &DeliveryStore{
+		ttl:  ttl,
+		seen: map[string]time.Time{},
+		now:  time.Now,
+	}
+}
+
+// Record a delivery ID, returning true if it was already recorded within the TTL. Empty
+// IDs are never duplicates.
+func (s *DeliveryStore) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for seenID, at := range s.seen {
+		if now.Sub(at) >= s.ttl {
+			delete(s.seen, seenID)
+		}
+	}
+
+	if _, ok := s.seen[id]; ok {
+		return true
+	}
+	s.seen[id] = now
+	return false
+}
+
+// Forget a delivery ID so that it is no longer a duplicate.
+func (s *DeliveryStore) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seen, id)
+}
+
+// Locks on the pull requests being worked on so that concurrent deliveries for the same
+// changes don't do the same work twice (such as posting two reviews).
+type PullRequestLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+	// Closed (and replaced) whenever a lock is released to wake up Wait
+	released chan struct{}
+}
+
+func NewPullRequestLocks() *PullRequestLocks {
+	return &PullRequestLocks{
+		held:     map[string]bool{},
+		released: make(chan struct{}),
+	}
+}
+
+// Take the lock on a pull request at a head SHA, returning false without waiting if it is
+// already held. The lock must be released with Unlock.
+func (l *PullRequestLocks) TryLock(owner, repo string, number int, sha string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := pullRequestKey(owner, repo, number, sha)
+	if l.held[key] {
+		return false
+	}
+	l.held[key] = true
+	return true
+}
+
+func (l *PullRequestLocks) Unlock(owner, repo string, number int, sha string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.held, pullRequestKey(owner, repo, number, sha))
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// Wait until no locks are held, or until the context is done. Used to let the work on
+// pull requests finish before shutting down.
+func (l *PullRequestLocks) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if len(l.held) == 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func pullRequestKey(owner, repo string, number int, sha string) string {
+	return fmt.Sprintf("%s/%s#%d@%s", owner, repo, number, sha)
+}
@@ -0,0 +1,100 @@
+package nit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryStore(t *testing.T) {
+	t.Run("should report redeliveries within the TTL", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		store := NewDeliveryStore(time.Hour)
+		store.now = func() time.Time { return now }
+
+		assert.False(t, store.Seen("a"))
+		assert.True(t, store.Seen("a"))
+		assert.False(t, store.Seen("b"))
+
+		now = now.Add(time.Hour)
+		assert.False(t, store.Seen("a"))
+	})
+
+	t.Run("should not report forgotten deliveries", func(t *testing.T) {
+		store := NewDeliveryStore(time.Hour)
+
+		assert.False(t, store.Seen("a"))
+		store.Forget("a")
+		assert.False(t, store.Seen("a"))
+		assert.True(t, store.Seen("a"))
+	})
+
+	t.Run("should never report empty delivery IDs", func(t *testing.T) {
+		store := NewDeliveryStore(time.Hour)
+
+		assert.False(t, store.Seen(""))
+		assert.False(t, store.Seen(""))
+	})
+}
+
+func TestPullRequestLocks(t *testing.T) {
+	t.Run("should only let one caller hold the lock on a pull request at a SHA", func(t *testing.T) {
+		locks := NewPullRequestLocks()
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			taken int
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if locks.TryLock("owner", "repo", 1, "abc") {
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, taken)
+		assert.True(t, locks.TryLock("owner", "repo", 1, "def"))
+		assert.True(t, locks.TryLock("owner", "repo", 2, "abc"))
+	})
+
+	t.Run("should release the lock", func(t *testing.T) {
+		locks := NewPullRequestLocks()
+
+		assert.True(t, locks.TryLock("owner", "repo", 1, "abc"))
+		locks.Unlock("owner", "repo", 1, "abc")
+		assert.True(t, locks.TryLock("owner", "repo", 1, "abc"))
+	})
+	t.Run("should wait until every lock is released", func(t *testing.T) {
+		locks := NewPullRequestLocks()
+		locks.TryLock("owner", "repo", 1, "abc")
+		locks.TryLock("owner", "repo", 2, "abc")
+
+		go func() {
+			locks.Unlock("owner", "repo", 1, "abc")
+			locks.Unlock("owner", "repo", 2, "abc")
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, locks.Wait(ctx))
+	})
+
+	t.Run("should stop waiting when the context is done", func(t *testing.T) {
+		locks := NewPullRequestLocks()
+		locks.TryLock("owner", "repo", 1, "abc")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, locks.Wait(ctx), context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,319 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// The command developers can comment on a pull request to have a description generated.
+const describeCommand = "/nit describe"
+
+// Added to generated descriptions so they can be told apart from ones written by people.
+const describeMarker = "<!-- generated by nit -->"
+
+var (
+	htmlCommentRegex  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	templateLineRegex = regexp.MustCompile(`(?m)^\s*(?:#+.*|[-*]\s*\[[ xX]?\].*|[-*]\s*|>.*)$`)
+	// The ai-review markers (such as ai-review:please) aren't part of a description
+	markerRegex          = regexp.MustCompile(`ai-review:[a-z-]+`)
+	pullRequestTemplates = []string{
+		".github/pull_request_template.md",
+		".github/PULL_REQUEST_TEMPLATE.md",
+		"PULL_REQUEST_TEMPLATE.md",
+		"pull_request_template.md",
+		"docs/pull_request_template.md",
+	}
+	// The pull request template of each repository and base commit, so that the template
+	// paths are only looked up once per commit
+	pullRequestTemplateCache = newBoundedCache[string, cachedTemplate](templateCacheSize)
+)
+
+// The number of pull request templates (one per repository and commit) kept in memory.
+const templateCacheSize = 64
+
+type DescribeResponse struct {
+	Tokens int
+	Id     int64
+	// The description that would have been posted when it was a dry run
+	DryRun *DryRun
+}
+
+func ShouldDescribePullRequest(ctx context.Context, e *github.PullRequestEvent, gh *github.Client, c *Config) (bool, string) {
+	var (
+		author      = e.GetPullRequest().GetUser().GetLogin()
+		action      = e.GetAction()
+		owner       = e.GetRepo().GetOwner().GetLogin()
+		repository  = e.GetRepo().GetName()
+		description = e.GetPullRequest().GetBody()
+		describe    = c.ForRepo(owner, repository).Describe
+	)
+
+	switch {
+	// ignore pull requests oppened by bots
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author, "[bot]"):
+		return false, "pull request made by a bot"
+	// descriptions are only generated for pull requests that are just opened
+	case action != "opened":
+		return false, "pull request was not \"opened\""
+	case describe == DescribeOff || describe == "":
+		return false, "generating descriptions is turned off"
+	// the ai-review:ignore string can be added to the PR description by a dev to
+	// prevent the app from touching the pull request
+	case strings.Contains(description, "ai-review:ignore"):
+		return false, "pull request marked as ignore"
+	// the ai-review:please string must be added to PR descriptions by a dev when
+	// OptIn is set to true
+	case c.OptIn && !strings.Contains(description, "ai-review:please"):
+		return false, "description not requested when opt-in is enabled"
+	case !isEmptyDescription(ctx, owner, repository, e.GetPullRequest().GetBase().GetSHA(), description, gh):
+		return false, "pull request already has a description"
+	default:
+		return true, ""
+	}
+}
+
+func ShouldDescribeOnCommand(e *github.IssueCommentEvent, c *Config) (bool, string) {
+	var (
+		author     = e.GetComment().GetUser().GetLogin()
+		action     = e.GetAction()
+		owner      = e.GetRepo().GetOwner().GetLogin()
+		repository = e.GetRepo().GetName()
+		body       = e.GetComment().GetBody()
+		describe   = c.ForRepo(owner, repository).Describe
+	)
+
+	switch {
+	// ignore comments by bots (including our own app)
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author, "[bot]"):
+		return false, "comment made by a bot"
+	// we will only handle comments that are just created (not edited, deleted, etc)
+	case action != "created":
+		return false, "comment was not \"created\""
+	// conversation comments on issues are delivered with the same event
+	case !e.GetIssue().IsPullRequest():
+		return false, "comment is not on a pull request"
+	case !hasCommand(body, describeCommand):
+		return false, "comment is not a describe command"
+	case describe == DescribeOff || describe == "":
+		return false, "generating descriptions is turned off"
+	default:
+		return true, ""
+	}
+}
+
+func DescribePullRequest(ctx context.Context, event *github.PullRequestEvent, config *Config, ai *AI, gh *github.Client) (*DescribeResponse, error) {
+	return describePullRequest(
+		ctx,
+		event.GetRepo().GetOwner().GetLogin(),
+		event.GetRepo().GetName(),
+		event.GetPullRequest().GetNumber(),
+		event.GetPullRequest().GetBase().GetSHA(),
+		event.GetPullRequest().GetTitle(),
+		event.GetPullRequest().GetBody(),
+		config,
+		ai,
+		gh,
+	)
+}
+
+func DescribePullRequestOnCommand(ctx context.Context, event *github.IssueCommentEvent, config *Config, ai *AI, gh *github.Client) (*DescribeResponse, error) {
+	return describePullRequest(
+		ctx,
+		event.GetRepo().GetOwner().GetLogin(),
+		event.GetRepo().GetName(),
+		event.GetIssue().GetNumber(),
+		// the event doesn't have the base commit, the template is read from the default branch
+		"",
+		event.GetIssue().GetTitle(),
+		event.GetIssue().GetBody(),
+		config,
+		ai,
+		gh,
+	)
+}
+
+func describePullRequest(ctx context.Context, owner, repository string, number int, baseSHA, title, description string, config *Config, ai *AI, gh *github.Client) (*DescribeResponse, error) {
+	var diff string
+	err := retryRateLimit(func() (err error) {
+		diff, _, err = gh.PullRequests.GetRaw(
+			ctx,
+			owner,
+			repository,
+			number,
+			github.RawOptions{Type: github.Diff},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	details := &PullRequestDetails{
+		Number:      number,
+		Title:       title,
+		Description: description,
+		Commits:     getCommitMessages(ctx, owner, repository, number, config.MaxPages, gh),
+	}
+
+	generated, err := ai.GeneratePullRequestDescription(ctx, details, diff)
+	if err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf("%s\n%s", describeMarker, generated.Completion)
+	dryRun := isDryRun(config, owner, repository, description)
+
+	if config.ForRepo(owner, repository).Describe == DescribeUpdate {
+		// Replace a description we generated before rather than adding another one
+		if before, _, found := strings.Cut(description, describeMarker); found {
+			description = strings.TrimSuffix(strings.TrimSpace(before), "---")
+		}
+		// Keep whatever the author wrote, unless it's only the template
+		if !isEmptyDescription(ctx, owner, repository, baseSHA, description, gh) {
+			body = fmt.Sprintf("%s\n\n---\n\n%s", strings.TrimSpace(description), body)
+		}
+
+		if dryRun {
+			run := &DryRun{
+				Owner:  owner,
+				Repo:   repository,
+				Number: number,
+				Tokens: generated.Tokens,
+				Action: dryRunDescribe,
+				Reply:  body,
+			}
+			return describeDryRun(config, ai, run)
+		}
+
+		var pr *github.PullRequest
+		err := retryRateLimit(func() (err error) {
+			pr, _, err = gh.PullRequests.Edit(
+				ctx,
+				owner,
+				repository,
+				number,
+				&github.PullRequest{Body: github.String(body)},
+			)
+			return err
+		})
+		if err != nil {
+			return &DescribeResponse{Tokens: generated.Tokens}, err
+		}
+		ai.Logger().Info("updated pull request description", "stage", stageDescribe, "tokens", generated.Tokens)
+		return &DescribeResponse{Tokens: generated.Tokens, Id: pr.GetID()}, nil
+	}
+
+	// Commenting the description is a reply on the pull request conversation
+	if dryRun {
+		run := &DryRun{
+			Owner:  owner,
+			Repo:   repository,
+			Number: number,
+			Tokens: generated.Tokens,
+			Action: dryRunDescribe,
+			Target: "in a comment",
+			Reply:  body,
+		}
+		return describeDryRun(config, ai, run)
+	}
+
+	var comment *github.IssueComment
+	err = retryRateLimit(func() (err error) {
+		comment, _, err = gh.Issues.CreateComment(
+			ctx,
+			owner,
+			repository,
+			number,
+			&github.IssueComment{Body: github.String(body)},
+		)
+		return err
+	})
+	if err != nil {
+		return &DescribeResponse{Tokens: generated.Tokens}, err
+	}
+	ai.Logger().Info("commented pull request description", "stage", stageDescribe, "comment_id", comment.GetID(), "tokens", generated.Tokens)
+
+	return &DescribeResponse{
+		Tokens: generated.Tokens,
+		Id:     comment.GetID(),
+	}, nil
+}
+
+// Write the dry run of a description instead of posting it.
+func describeDryRun(config *Config, ai *AI, run *DryRun) (*DescribeResponse, error) {
+	if err := writeDryRun(config, ai, stageDescribe, run); err != nil {
+		return &DescribeResponse{Tokens: run.Tokens}, err
+	}
+	return &DescribeResponse{Tokens: run.Tokens, DryRun: run}, nil
+}
+
+// Check if a pull request description has no content written by the author. Descriptions
+// that only contain the repository's pull request template, or the headings, checkboxes and
+// comments typical of templates, are considered empty. The template is read at the ref,
+// the default branch when it is empty.
+func isEmptyDescription(ctx context.Context, owner, repo, ref, description string, gh *github.Client) bool {
+	stripped := stripTemplate(description)
+	if stripped == "" {
+		return true
+	}
+
+	template, found := getPullRequestTemplate(ctx, owner, repo, ref, gh)
+	return found && stripped == stripTemplate(template)
+}
+
+// Get the first pull request template found in the repository. Templates are cached per
+// commit, the default branch (an empty ref) is looked up every time since it can change.
+func getPullRequestTemplate(ctx context.Context, owner, repo, ref string, gh *github.Client) (string, bool) {
+	key := fmt.Sprintf("%s/%s@%s", owner, repo, ref)
+	if ref != "" {
+		if template, ok := pullRequestTemplateCache.get(key); ok {
+			return template.content, template.found
+		}
+	}
+
+	template := cachedTemplate{}
+	for _, path := range pullRequestTemplates {
+		content, err := getFileContent(ctx, owner, repo, ref, path, gh)
+		if err != nil {
+			continue
+		}
+		template = cachedTemplate{content: content, found: true}
+		break
+	}
+
+	if ref != "" {
+		pullRequestTemplateCache.add(key, template)
+	}
+	return template.content, template.found
+}
+
+// Remove the parts of a description that typically come from a template (comments,
+// headings, checkboxes, quotes) or ask something of the app (ai-review markers) and
+// normalize the whitespace in the rest.
+func stripTemplate(description string) string {
+	stripped := htmlCommentRegex.ReplaceAllString(description, "")
+	stripped = markerRegex.ReplaceAllString(stripped, "")
+	stripped = templateLineRegex.ReplaceAllString(stripped, "")
+	return strings.Join(strings.Fields(stripped), " ")
+}
+
+// Check if a comment contains a command on a line of its own.
+func hasCommand(body, command string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// The pull request template of a repository, or that it doesn't have one
+type cachedTemplate struct {
+	content string
+	found   bool
+}
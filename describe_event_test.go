@@ -0,0 +1,357 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDescribePullRequest(t *testing.T) {
+	mockGithub := github.NewClient(ghMock.NewMockedHTTPClient())
+
+	createEvent := func(action, description string) *github.PullRequestEvent {
+		return &github.PullRequestEvent{
+			Action: github.String(action),
+			Repo: &github.Repository{
+				Name: github.String("repo"),
+				Owner: &github.User{
+					Login: github.String("owner"),
+				},
+			},
+			PullRequest: &github.PullRequest{
+				Body:  github.String(description),
+				Title: github.String("title"),
+				User: &github.User{
+					Login: github.String("user"),
+				},
+			},
+		}
+	}
+
+	t.Run("should describe opened pull requests with empty or template only descriptions", func(t *testing.T) {
+		descriptions := []string{
+			"",
+			"  \n ",
+			"## Description\n<!-- describe your changes -->\n\n## Checklist\n- [ ] tests added\n- [x] docs updated\n",
+		}
+
+		for _, description := range descriptions {
+			ok, _ := ShouldDescribePullRequest(context.Background(), createEvent("opened", description), mockGithub, &Config{RepoConfig: RepoConfig{Describe: DescribeComment}})
+			assert.True(t, ok)
+		}
+	})
+
+	t.Run("should ignore pull requests with a description", func(t *testing.T) {
+		event := createEvent("opened", "## Description\nThis changes things")
+
+		ok, _ := ShouldDescribePullRequest(context.Background(), event, mockGithub, &Config{RepoConfig: RepoConfig{Describe: DescribeComment}})
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore pull requests that are not status opened", func(t *testing.T) {
+		event := createEvent("synchronize", "")
+
+		ok, _ := ShouldDescribePullRequest(context.Background(), event, mockGithub, &Config{RepoConfig: RepoConfig{Describe: DescribeComment}})
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore pull requests when describing is turned off for the repository", func(t *testing.T) {
+		event := createEvent("opened", "")
+		config := &Config{
+			RepoConfig: RepoConfig{Describe: DescribeComment},
+			Repos: map[string]RepoConfig{
+				"owner/repo": {Describe: DescribeOff},
+			},
+		}
+
+		ok, _ := ShouldDescribePullRequest(context.Background(), event, mockGithub, config)
+		assert.False(t, ok)
+
+		ok, _ = ShouldDescribePullRequest(context.Background(), event, mockGithub, &Config{})
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore pull requests marked as ignore", func(t *testing.T) {
+		event := createEvent("opened", "ai-review:ignore")
+
+		ok, _ := ShouldDescribePullRequest(context.Background(), event, mockGithub, &Config{RepoConfig: RepoConfig{Describe: DescribeComment}})
+		assert.False(t, ok)
+	})
+
+	t.Run("should only describe pull requests that ask for it when opt-in is enabled", func(t *testing.T) {
+		config := &Config{OptIn: true, RepoConfig: RepoConfig{Describe: DescribeComment}}
+
+		ok, _ := ShouldDescribePullRequest(context.Background(), createEvent("opened", ""), mockGithub, config)
+		assert.False(t, ok)
+
+		ok, _ = ShouldDescribePullRequest(context.Background(), createEvent("opened", "ai-review:please"), mockGithub, config)
+		assert.True(t, ok)
+	})
+}
+
+func TestShouldDescribeOnCommand(t *testing.T) {
+	createEvent := func(author, body string, pr bool) *github.IssueCommentEvent {
+		issue := &github.Issue{Number: github.Int(1)}
+		if pr {
+			issue.PullRequestLinks = &github.PullRequestLinks{URL: github.String("url")}
+		}
+		return &github.IssueCommentEvent{
+			Action: github.String("created"),
+			Repo: &github.Repository{
+				Name: github.String("repo"),
+				Owner: &github.User{
+					Login: github.String("owner"),
+				},
+			},
+			Issue: issue,
+			Comment: &github.IssueComment{
+				Body: github.String(body),
+				User: &github.User{
+					Login: github.String(author),
+				},
+			},
+		}
+	}
+	config := &Config{RepoConfig: RepoConfig{Describe: DescribeUpdate}}
+
+	t.Run("should describe pull requests when the command is commented", func(t *testing.T) {
+		ok, _ := ShouldDescribeOnCommand(createEvent("user", "please\n/nit describe\n", true), config)
+		assert.True(t, ok)
+	})
+
+	t.Run("should ignore comments without the command", func(t *testing.T) {
+		ok, _ := ShouldDescribeOnCommand(createEvent("user", "I'll /nit describe later", true), config)
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore comments on issues", func(t *testing.T) {
+		ok, _ := ShouldDescribeOnCommand(createEvent("user", "/nit describe", false), config)
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore comments made by bots", func(t *testing.T) {
+		ok, _ := ShouldDescribeOnCommand(createEvent("something[bot]", "/nit describe", true), config)
+		assert.False(t, ok)
+	})
+}
+
+func TestDescribePullRequest(t *testing.T) {
+	const (
+		diff        = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3"
+		generated   = "## Summary\nChanges lines"
+		description = "<!-- describe your changes -->"
+	)
+
+	event := &github.PullRequestEvent{
+		Action: github.String("opened"),
+		Repo: &github.Repository{
+			Name: github.String("repo"),
+			Owner: &github.User{
+				Login: github.String("owner"),
+			},
+		},
+		PullRequest: &github.PullRequest{
+			Number: github.Int(1),
+			Body:   github.String(description),
+			Title:  github.String("title"),
+			User: &github.User{
+				Login: github.String("user"),
+			},
+		},
+	}
+
+	setupProviderMock := func() *AIProviderMock {
+		return &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: generated, Tokens: 10}, nil
+			},
+		}
+	}
+
+	diffMock := ghMock.WithRequestMatchHandler(
+		ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(diff))
+		}),
+	)
+
+	t.Run("should post the generated description as a comment", func(t *testing.T) {
+		mockProvider := setupProviderMock()
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		var commentPayload *github.IssueComment
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			diffMock,
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&commentPayload)
+					w.Write([]byte(""))
+				}),
+			),
+		))
+
+		res, err := DescribePullRequest(context.Background(), event, &Config{RepoConfig: RepoConfig{Describe: DescribeComment}}, mockAI, mockGithub)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, res.Tokens)
+
+		assert.Equal(t, describeMarker+"\n"+generated, commentPayload.GetBody())
+
+		gotAI := mockProvider.calls.CreateCompletetion[0].Req
+		wantAI := &CompletionRequest{
+			Prompt: fmt.Sprintf(describePrompt, formatPullRequestDetails(&PullRequestDetails{Number: 1, Title: "title", Description: description}), diff, "file.txt"),
+			Model:  modelGood,
+			Format: formatText,
+		}
+		assert.Equal(t, wantAI, gotAI)
+	})
+
+	t.Run("should replace a template only pull request body", func(t *testing.T) {
+		mockProvider := setupProviderMock()
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		var prPayload *github.PullRequest
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			diffMock,
+			ghMock.WithRequestMatchHandler(
+				ghMock.PatchReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&prPayload)
+					w.Write([]byte(""))
+				}),
+			),
+		))
+
+		_, err := DescribePullRequest(context.Background(), event, &Config{RepoConfig: RepoConfig{Describe: DescribeUpdate}}, mockAI, mockGithub)
+		assert.Nil(t, err)
+
+		assert.Equal(t, describeMarker+"\n"+generated, prPayload.GetBody())
+	})
+
+	t.Run("should keep the author's description and replace earlier generated descriptions", func(t *testing.T) {
+		mockProvider := setupProviderMock()
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		var prPayload *github.PullRequest
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			diffMock,
+			ghMock.WithRequestMatchHandler(
+				ghMock.PatchReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&prPayload)
+					w.Write([]byte(""))
+				}),
+			),
+		))
+
+		commandEvent := &github.IssueCommentEvent{
+			Action: github.String("created"),
+			Repo:   event.Repo,
+			Issue: &github.Issue{
+				Number: github.Int(1),
+				Title:  github.String("title"),
+				Body:   github.String("Fixes the thing\n\n---\n\n" + describeMarker + "\nold description"),
+			},
+		}
+
+		_, err := DescribePullRequestOnCommand(context.Background(), commandEvent, &Config{RepoConfig: RepoConfig{Describe: DescribeUpdate}}, mockAI, mockGithub)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "Fixes the thing\n\n---\n\n"+describeMarker+"\n"+generated, prPayload.GetBody())
+	})
+
+	t.Run("should write the description instead of posting it in a dry run", func(t *testing.T) {
+		posted := false
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			diffMock,
+			ghMock.WithRequestMatchHandler(
+				ghMock.PatchReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					posted = true
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					posted = true
+				}),
+			),
+		))
+
+		for _, describe := range []string{DescribeUpdate, DescribeComment} {
+			mockProvider := setupProviderMock()
+			mockAI := NewAI(mockProvider, mockProvider)
+			writer := &dryRunWriterMock{}
+			config := &Config{DryRunWriter: writer, RepoConfig: RepoConfig{Describe: describe, DryRun: DryRunOn}}
+
+			res, err := DescribePullRequest(context.Background(), event, config, mockAI, mockGithub)
+
+			assert.Nil(t, err)
+			assert.Len(t, writer.runs, 1)
+			assert.Equal(t, writer.runs[0], res.DryRun)
+			assert.Equal(t, describeMarker+"\n"+generated, res.DryRun.Reply)
+			assert.Equal(t, dryRunDescribe, res.DryRun.Action)
+		}
+		assert.False(t, posted)
+	})
+}
+
+func TestGetPullRequestTemplate(t *testing.T) {
+	template := "## Description\n\n## Checklist\n- [ ] tests added\n"
+	requests := 0
+	mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetReposContentsByOwnerByRepoByPath,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.URL.Path != "/repos/owner/templates/contents/PULL_REQUEST_TEMPLATE.md" {
+					ghMock.WriteError(w, http.StatusNotFound, "not found")
+					return
+				}
+				w.Write(ghMock.MustMarshal(github.RepositoryContent{
+					Type:     github.String("file"),
+					Encoding: github.String(""),
+					Content:  github.String(template),
+				}))
+			}),
+		),
+	))
+
+	t.Run("should stop at the first template found", func(t *testing.T) {
+		requests = 0
+		got, found := getPullRequestTemplate(context.Background(), "owner", "templates", "abc", mockGithub)
+
+		assert.True(t, found)
+		assert.Equal(t, template, got)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("should look up the templates once per commit", func(t *testing.T) {
+		requests = 0
+		_, found := getPullRequestTemplate(context.Background(), "owner", "none", "abc", mockGithub)
+		assert.False(t, found)
+		assert.Equal(t, len(pullRequestTemplates), requests)
+
+		_, found = getPullRequestTemplate(context.Background(), "owner", "none", "abc", mockGithub)
+		assert.False(t, found)
+		assert.Equal(t, len(pullRequestTemplates), requests)
+
+		_, found = getPullRequestTemplate(context.Background(), "owner", "none", "def", mockGithub)
+		assert.False(t, found)
+		assert.Equal(t, 2*len(pullRequestTemplates), requests)
+	})
+
+	t.Run("should look up the default branch every time", func(t *testing.T) {
+		requests = 0
+		getPullRequestTemplate(context.Background(), "owner", "none", "", mockGithub)
+		getPullRequestTemplate(context.Background(), "owner", "none", "", mockGithub)
+
+		assert.Equal(t, 2*len(pullRequestTemplates), requests)
+	})
+}
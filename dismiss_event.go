@@ -0,0 +1,186 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const dismissMessage = "The blocking issues were resolved in %s."
+
+type DismissResponse struct {
+	Tokens int
+	// The ID of the dismissed review, 0 when the review is still blocking
+	Id int64
+	// The dismissal that would have been made when it was a dry run
+	DryRun *DryRun
+}
+
+func ShouldDismissStaleReview(e *github.PullRequestEvent, c *Config) (bool, string) {
+	var (
+		author      = e.GetPullRequest().GetUser().GetLogin()
+		action      = e.GetAction()
+		description = e.GetPullRequest().GetBody()
+	)
+
+	switch {
+	// ignore pull requests oppened by bots
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author, "[bot]"):
+		return false, "pull request made by a bot"
+	// only pushes to the pull request can resolve blocking issues
+	case action != "synchronize":
+		return false, "pull request was not \"synchronize\""
+	case strings.Contains(description, "ai-review:ignore"):
+		return false, "pull request marked as ignore"
+	default:
+		return true, ""
+	}
+}
+
+// Dismiss the review that requested changes on a pull request when the blocking issues it
+// raised are fixed by the latest changes. Nothing happens when there isn't a review
+// requesting changes or when blocking issues remain.
+func DismissStaleReview(ctx context.Context, event *github.PullRequestEvent, config *Config, ai *AI, gh *github.Client) (*DismissResponse, error) {
+	var (
+		owner      = event.GetRepo().GetOwner().GetLogin()
+		repository = event.GetRepo().GetName()
+		number     = event.GetPullRequest().GetNumber()
+		headSHA    = event.GetPullRequest().GetHead().GetSHA()
+	)
+
+	stale, err := getBlockingReview(ctx, owner, repository, number, config.AppName, config.MaxPages, gh)
+	if err != nil {
+		return nil, err
+	}
+	if stale == nil {
+		ai.Logger().Debug("no review is requesting changes", "stage", stageDismiss)
+		return &DismissResponse{}, nil
+	}
+
+	blockers, err := getBlockingComments(ctx, owner, repository, number, stale.GetID(), config.MaxPages, gh)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := 0
+	if len(blockers) > 0 {
+		var diff string
+		err := retryRateLimit(func() (err error) {
+			diff, _, err = gh.PullRequests.GetRaw(
+				ctx,
+				owner,
+				repository,
+				number,
+				github.RawOptions{Type: github.Diff},
+			)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		issues, resp, err := ai.generateBlockingIssues(ctx, blockers, diff)
+		if resp != nil {
+			tokens = resp.Tokens
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(issues.Remaining) > 0 {
+			ai.Logger().Info("not dismissing review with blockers remaining", "stage", stageDismiss, "review_id", stale.GetID(), "blockers", len(issues.Remaining), "tokens", tokens)
+			return &DismissResponse{Tokens: tokens}, nil
+		}
+	}
+
+	message := fmt.Sprintf(dismissMessage, headSHA)
+	if isDryRun(config, owner, repository, event.GetPullRequest().GetBody()) {
+		run := &DryRun{
+			Owner:   owner,
+			Repo:    repository,
+			Number:  number,
+			HeadSHA: headSHA,
+			Tokens:  tokens,
+			Action:  dryRunDismiss,
+			Target:  fmt.Sprintf("review %d", stale.GetID()),
+			Reply:   message,
+		}
+		if err := writeDryRun(config, ai, stageDismiss, run); err != nil {
+			return &DismissResponse{Tokens: tokens}, err
+		}
+		return &DismissResponse{Tokens: tokens, DryRun: run}, nil
+	}
+
+	var review *github.PullRequestReview
+	err = retryRateLimit(func() (err error) {
+		review, _, err = gh.PullRequests.DismissReview(
+			ctx,
+			owner,
+			repository,
+			number,
+			stale.GetID(),
+			&github.PullRequestReviewDismissalRequest{
+				Message: github.String(message),
+			},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ai.Logger().Info("dismissed stale review", "stage", stageDismiss, "review_id", review.GetID(), "tokens", tokens)
+
+	return &DismissResponse{
+		Tokens: tokens,
+		Id:     review.GetID(),
+	}, nil
+}
+
+// Get the comments of a review that raised blocking issues. Blockers always stay inline so
+// they can be found by the severity marker at the start of the comment.
+func getBlockingComments(ctx context.Context, owner, repo string, number int, reviewID int64, maxPages int, gh *github.Client) ([]*github.PullRequestComment, error) {
+	comments, err := paginate(maxPages, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+		return gh.PullRequests.ListReviewComments(ctx, owner, repo, number, reviewID, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := []*github.PullRequestComment{}
+	for _, comment := range comments {
+		if parseSeverityMarker(comment.GetBody()) == SeverityBlocker {
+			blockers = append(blockers, comment)
+		}
+	}
+	return blockers, nil
+}
+
+// Get the review by the app that is currently requesting changes on a pull request, nil if
+// there isn't one. Only the latest review by the app that approved, requested changes or
+// was dismissed counts, reviews that only comment don't change whether changes are requested.
+func getBlockingReview(ctx context.Context, owner, repo string, number int, name string, maxPages int, gh *github.Client) (*github.PullRequestReview, error) {
+	reviews, err := paginate(maxPages, func(opts *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error) {
+		return gh.PullRequests.ListReviews(ctx, owner, repo, number, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *github.PullRequestReview
+	for _, review := range reviews {
+		if name == "" || !strings.Contains(review.GetUser().GetLogin(), name) {
+			continue
+		}
+		if review.GetState() == "COMMENTED" || review.GetState() == "PENDING" {
+			continue
+		}
+		latest = review
+	}
+
+	if latest == nil || latest.GetState() != "CHANGES_REQUESTED" {
+		return nil, nil
+	}
+	return latest, nil
+}
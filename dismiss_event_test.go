@@ -0,0 +1,204 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDismissStaleReview(t *testing.T) {
+	createEvent := func(action, author string) *github.PullRequestEvent {
+		return &github.PullRequestEvent{
+			Action: github.String(action),
+			PullRequest: &github.PullRequest{
+				Body: github.String("body"),
+				User: &github.User{Login: github.String(author)},
+			},
+		}
+	}
+
+	t.Run("should check pushes to pull requests", func(t *testing.T) {
+		ok, _ := ShouldDismissStaleReview(createEvent("synchronize", "user"), &Config{})
+		assert.True(t, ok)
+	})
+
+	t.Run("should ignore other actions", func(t *testing.T) {
+		ok, _ := ShouldDismissStaleReview(createEvent("opened", "user"), &Config{})
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore pull requests made by bots", func(t *testing.T) {
+		ok, _ := ShouldDismissStaleReview(createEvent("synchronize", "something[bot]"), &Config{})
+		assert.False(t, ok)
+	})
+}
+
+func TestDismissStaleReview(t *testing.T) {
+	const diff = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3"
+
+	event := &github.PullRequestEvent{
+		Action: github.String("synchronize"),
+		Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("user")},
+		},
+		PullRequest: &github.PullRequest{
+			Body:   github.String("description"),
+			Title:  github.String("title"),
+			Number: github.Int(123),
+			User:   &github.User{Login: github.String("user")},
+			Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+		},
+	}
+	config := &Config{AppName: "nit", RepoConfig: RepoConfig{ReviewPolicy: ReviewPolicyRequestChanges}}
+
+	setupProviderMock := func(completion string) *AIProviderMock {
+		return &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: completion, Tokens: 10}, nil
+			},
+		}
+	}
+
+	reviews := []*github.PullRequestReview{
+		{ID: github.Int64(1), State: github.String("CHANGES_REQUESTED"), User: &github.User{Login: github.String("nit[bot]")}},
+		{ID: github.Int64(2), State: github.String("APPROVED"), User: &github.User{Login: github.String("someone")}},
+		{ID: github.Int64(3), State: github.String("COMMENTED"), User: &github.User{Login: github.String("nit[bot]")}},
+	}
+	comments := []*github.PullRequestComment{
+		{Path: github.String("file.txt"), DiffHunk: github.String("@@ -1,2 +1,3 @@"), Body: github.String("<!-- nit:severity=blocker -->\n**Blocker** · bug\n\nthis breaks")},
+		{Path: github.String("file.txt"), DiffHunk: github.String("@@ -1,2 +1,3 @@"), Body: github.String("<!-- nit:severity=nit -->\n**Nit**\n\nrename this")},
+	}
+
+	t.Run("should dismiss the review when the blocking issues are resolved", func(t *testing.T) {
+		mockProvider := setupProviderMock(`{"remaining": []}`)
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		var dismissal *github.PullRequestReviewDismissalRequest
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsByOwnerByRepoByPullNumber, reviews),
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsCommentsByOwnerByRepoByPullNumberByReviewId, comments),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PutReposPullsReviewsDismissalsByOwnerByRepoByPullNumberByReviewId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "/repos/user/repo/pulls/123/reviews/1/dismissals", r.URL.Path)
+					json.NewDecoder(r.Body).Decode(&dismissal)
+					w.Write([]byte(`{"id": 1}`))
+				}),
+			),
+		))
+
+		res, err := DismissStaleReview(context.Background(), event, config, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &DismissResponse{Tokens: 10, Id: 1}, res)
+		assert.Equal(t, "The blocking issues were resolved in abc123.", dismissal.GetMessage())
+		// only the blocker is checked, with a single cheap completion
+		calls := mockProvider.calls.CreateCompletetion
+		assert.Len(t, calls, 1)
+		assert.Equal(t, modelCheap, calls[0].Req.Model)
+		assert.Contains(t, calls[0].Req.Prompt, "this breaks")
+		assert.NotContains(t, calls[0].Req.Prompt, "rename this")
+	})
+
+	t.Run("should not dismiss the review when blocking issues remain", func(t *testing.T) {
+		mockProvider := setupProviderMock(`{"remaining": ["this still breaks"]}`)
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		dismissed := false
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsByOwnerByRepoByPullNumber, reviews),
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsCommentsByOwnerByRepoByPullNumberByReviewId, comments),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PutReposPullsReviewsDismissalsByOwnerByRepoByPullNumberByReviewId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					dismissed = true
+				}),
+			),
+		))
+
+		res, err := DismissStaleReview(context.Background(), event, config, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &DismissResponse{Tokens: 10}, res)
+		assert.False(t, dismissed)
+	})
+
+	t.Run("should write the dismissal instead of making it in a dry run", func(t *testing.T) {
+		mockProvider := setupProviderMock(`{"remaining": []}`)
+		mockAI := NewAI(mockProvider, mockProvider)
+		writer := &dryRunWriterMock{}
+
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsByOwnerByRepoByPullNumber, reviews),
+			ghMock.WithRequestMatch(ghMock.GetReposPullsReviewsCommentsByOwnerByRepoByPullNumberByReviewId, comments),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PutReposPullsReviewsDismissalsByOwnerByRepoByPullNumberByReviewId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the review should not be dismissed")
+				}),
+			),
+		))
+
+		config := &Config{AppName: "nit", DryRunWriter: writer, RepoConfig: RepoConfig{DryRun: DryRunOn}}
+		res, err := DismissStaleReview(context.Background(), event, config, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		want := &DryRun{
+			Owner:   "user",
+			Repo:    "repo",
+			Number:  123,
+			HeadSHA: "abc123",
+			Tokens:  10,
+			Action:  dryRunDismiss,
+			Target:  "review 1",
+			Reply:   "The blocking issues were resolved in abc123.",
+		}
+		assert.Equal(t, []*DryRun{want}, writer.runs)
+		assert.Equal(t, &DismissResponse{Tokens: 10, DryRun: want}, res)
+	})
+
+	t.Run("should do nothing when no review is requesting changes", func(t *testing.T) {
+		mockProvider := setupProviderMock("")
+		mockAI := NewAI(mockProvider, mockProvider)
+
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatch(
+				ghMock.GetReposPullsReviewsByOwnerByRepoByPullNumber,
+				[]*github.PullRequestReview{
+					{ID: github.Int64(1), State: github.String("CHANGES_REQUESTED"), User: &github.User{Login: github.String("nit[bot]")}},
+					{ID: github.Int64(2), State: github.String("DISMISSED"), User: &github.User{Login: github.String("nit[bot]")}},
+				},
+			),
+		))
+
+		res, err := DismissStaleReview(context.Background(), event, config, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &DismissResponse{}, res)
+		assert.Empty(t, mockProvider.calls.CreateCompletetion)
+	})
+}
@@ -0,0 +1,150 @@
+package nit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// Whether reviews, replies and other writes to pull requests are generated without being
+// posted.
+const (
+	DryRunOff = "off"
+	DryRunOn  = "on"
+)
+
+// The string a dev can add to a pull request description to try a review without it
+// being posted
+const dryRunMarker = "ai-review:dry-run"
+
+// A review, reply or other write to a pull request that was generated in a dry run instead
+// of being posted.
+type DryRun struct {
+	Owner   string `json:"owner"`
+	Repo    string `json:"repo"`
+	Number  int    `json:"number"`
+	HeadSHA string `json:"head_sha,omitempty"`
+	// The model that generated the review comments
+	Model  string `json:"model,omitempty"`
+	Tokens int    `json:"tokens"`
+	// The review that would have been created, nil for replies
+	Review *github.PullRequestReviewRequest `json:"review,omitempty"`
+	// The reply that would have been posted, and the ID of the comment it replies to (0
+	// for comments on the pull request conversation)
+	Reply     string `json:"reply,omitempty"`
+	InReplyTo int64  `json:"in_reply_to,omitempty"`
+	// What would have been done for writes other than reviews and replies (dryRunDescribe,
+	// dryRunDismiss or dryRunResolve) and what to, such as "review 1". The Reply is the
+	// description, the message of the dismissal or what is missing to resolve a thread.
+	Action string `json:"action,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// The actions of dry runs of writes other than reviews and replies
+const (
+	dryRunDescribe = "describe"
+	dryRunDismiss  = "dismiss"
+	dryRunResolve  = "resolve"
+)
+
+// Writes dry runs somewhere they can be looked at. Dry runs are logged when no writer is
+// configured.
+type DryRunWriter interface {
+	WriteDryRun(run *DryRun) error
+}
+
+// Check if a pull request should be handled as a dry run, either because dry runs are on
+// for the repository or because the description asks for one.
+func isDryRun(config *Config, owner, repo, description string) bool {
+	return config.ForRepo(owner, repo).DryRun == DryRunOn || strings.Contains(description, dryRunMarker)
+}
+
+// Write a dry run with the configured writer, or to the log when there isn't one.
+func writeDryRun(config *Config, ai *AI, stage string, run *DryRun) error {
+	if config.DryRunWriter != nil {
+		ai.Logger().Info("wrote dry run", "stage", stage, "tokens", run.Tokens)
+		return config.DryRunWriter.WriteDryRun(run)
+	}
+
+	payload, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	ai.Logger().Info("dry run", "stage", stage, "tokens", run.Tokens, "payload", string(payload))
+	return nil
+}
+
+// What the dry run is of, "review", "reply" or its action
+func (d *DryRun) kind() string {
+	switch {
+	case d.Review != nil:
+		return "review"
+	case d.Action != "":
+		return d.Action
+	default:
+		return "reply"
+	}
+}
+
+// Render the dry run the way it would have looked on GitHub.
+func (d *DryRun) Markdown() string {
+	var sb strings.Builder
+	if d.Action != "" {
+		title := strings.ToUpper(d.Action[:1]) + d.Action[1:]
+		if d.Target != "" {
+			title += " " + d.Target
+		}
+		sb.WriteString(fmt.Sprintf("# %s on %s/%s#%d\n", title, d.Owner, d.Repo, d.Number))
+		if d.InReplyTo != 0 {
+			sb.WriteString(fmt.Sprintf("\nReply to comment %d:\n", d.InReplyTo))
+		}
+		if d.Reply != "" {
+			sb.WriteString("\n" + d.Reply + "\n")
+		}
+		return sb.String()
+	}
+	if d.Review == nil {
+		sb.WriteString(fmt.Sprintf("# Reply on %s/%s#%d", d.Owner, d.Repo, d.Number))
+		if d.InReplyTo != 0 {
+			sb.WriteString(fmt.Sprintf(" to comment %d", d.InReplyTo))
+		}
+		sb.WriteString("\n\n" + d.Reply + "\n")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("# Review of %s/%s#%d at %s\n\n", d.Owner, d.Repo, d.Number, d.HeadSHA))
+	sb.WriteString(FormatReviewMarkdown(d.Review))
+	return sb.String()
+}
+
+// Writes each dry run to a directory as a JSON file and a Markdown file with the same name.
+type DirDryRunWriter struct {
+	dir string
+	now func() time.Time
+}
+
+// Create the writer, creating the directory if it doesn't exist.
+func NewDirDryRunWriter(dir string) (*DirDryRunWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirDryRunWriter{dir: dir, now: time.Now}, nil
+}
+
+func (w *DirDryRunWriter) WriteDryRun(run *DryRun) error {
+	name := fmt.Sprintf("%s-%s-%d-%s-%d", run.Owner, run.Repo, run.Number, run.kind(), w.now().UnixNano())
+
+	payload, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, name+".json"), payload, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.dir, name+".md"), []byte(run.Markdown()), 0o644)
+}
@@ -0,0 +1,101 @@
+package nit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects the dry runs written to it
+type dryRunWriterMock struct {
+	runs []*DryRun
+}
+
+func (w *dryRunWriterMock) WriteDryRun(run *DryRun) error {
+	w.runs = append(w.runs, run)
+	return nil
+}
+
+func TestIsDryRun(t *testing.T) {
+	t.Run("should be a dry run when it is on for the repository", func(t *testing.T) {
+		config := &Config{
+			RepoConfig: RepoConfig{DryRun: DryRunOn},
+			Repos: map[string]RepoConfig{
+				"owner/live": {DryRun: DryRunOff},
+			},
+		}
+
+		assert.True(t, isDryRun(config, "owner", "repo", ""))
+		assert.False(t, isDryRun(config, "owner", "live", ""))
+	})
+
+	t.Run("should be a dry run when the description asks for one", func(t *testing.T) {
+		assert.True(t, isDryRun(&Config{}, "owner", "repo", "changes\n\nai-review:dry-run"))
+		assert.False(t, isDryRun(&Config{}, "owner", "repo", "changes"))
+	})
+}
+
+func TestDryRunMarkdown(t *testing.T) {
+	t.Run("should render a review with its comments", func(t *testing.T) {
+		run := &DryRun{
+			Owner:   "owner",
+			Repo:    "repo",
+			Number:  1,
+			HeadSHA: "abc",
+			Review: &github.PullRequestReviewRequest{
+				Body:  github.String("Looks good"),
+				Event: github.String("COMMENT"),
+				Comments: []*github.DraftReviewComment{
+					{Path: github.String("a.go"), Position: github.Int(3), Body: github.String("first")},
+					{Path: github.String("b.go"), StartLine: github.Int(1), Line: github.Int(2), Body: github.String("second")},
+				},
+			},
+		}
+
+		expected := "# Review of owner/repo#1 at abc\n\n**Event:** COMMENT\n\nLooks good\n\n## Comments\n\n### `a.go` position 3\n\nfirst\n\n### `b.go` lines 1-2\n\nsecond\n"
+		assert.Equal(t, expected, run.Markdown())
+	})
+
+	t.Run("should render a reply", func(t *testing.T) {
+		run := &DryRun{Owner: "owner", Repo: "repo", Number: 1, Reply: "Fixed", InReplyTo: 9}
+
+		assert.Equal(t, "# Reply on owner/repo#1 to comment 9\n\nFixed\n", run.Markdown())
+	})
+
+	t.Run("should render other writes with what they would do", func(t *testing.T) {
+		dismiss := &DryRun{Owner: "owner", Repo: "repo", Number: 1, Action: dryRunDismiss, Target: "review 5", Reply: "Resolved"}
+		resolve := &DryRun{Owner: "owner", Repo: "repo", Number: 1, Action: dryRunResolve, Target: "thread T1"}
+		missing := &DryRun{Owner: "owner", Repo: "repo", Number: 1, Action: dryRunResolve, Target: "thread T1", Reply: "Add a test", InReplyTo: 9}
+
+		assert.Equal(t, "# Dismiss review 5 on owner/repo#1\n\nResolved\n", dismiss.Markdown())
+		assert.Equal(t, "# Resolve thread T1 on owner/repo#1\n", resolve.Markdown())
+		assert.Equal(t, "# Resolve thread T1 on owner/repo#1\n\nReply to comment 9:\n\nAdd a test\n", missing.Markdown())
+	})
+}
+
+func TestDirDryRunWriter(t *testing.T) {
+	t.Run("should write the dry run as JSON and Markdown", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "dry-runs")
+		writer, err := NewDirDryRunWriter(dir)
+		assert.NoError(t, err)
+		writer.now = func() time.Time { return time.Unix(0, 42) }
+
+		run := &DryRun{Owner: "owner", Repo: "repo", Number: 1, Reply: "Fixed", InReplyTo: 9}
+		assert.NoError(t, writer.WriteDryRun(run))
+
+		content, err := os.ReadFile(filepath.Join(dir, "owner-repo-1-reply-42.json"))
+		assert.NoError(t, err)
+		var written DryRun
+		assert.NoError(t, json.Unmarshal(content, &written))
+		assert.Equal(t, *run, written)
+
+		content, err = os.ReadFile(filepath.Join(dir, "owner-repo-1-reply-42.md"))
+		assert.NoError(t, err)
+		assert.Equal(t, run.Markdown(), string(content))
+	})
+}
@@ -0,0 +1,284 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	// The approximate number of tokens worth of file contents that will be added to the
+	// review prompt. Whole files are included when they all fit, otherwise only the
+	// regions surrounding the changes are.
+	fileContextTokenBudget = 12000
+	// The number of lines above and below each hunk to include when a whole file
+	// doesn't fit in the token budget.
+	fileContextLines = 30
+)
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// A range of line numbers (inclusive) in a file.
+type lineRange struct {
+	Start int
+	End   int
+}
+
+// A file changed in a pull request diff. Line numbers refer to the head version of the file.
+type changedFile struct {
+	Path string
+	// The path of the file before the change, this differs from Path for renamed files
+	OldPath string
+	Deleted bool
+	// The line ranges covered by each hunk in the diff
+	Hunks []lineRange
+	// The lines added or modified by the diff
+	Changed map[int]bool
+	// The lines of the diff by position, Positions[0] is position 1
+	Positions []diffPosition
+}
+
+// A line in the diff that a review comment can be left on.
+type diffPosition struct {
+	// The index of the hunk the line belongs to
+	Hunk int
+	// The line number in the head version of the file, 0 for removed lines and hunk headers
+	Line int
+}
+
+// The head version of a file changed in a pull request.
+type fileContent struct {
+	*changedFile
+	Lines []string
+}
+
+// Parse a git diff into the files that it changes along with the line numbers (in the new
+// version of each file) covered by the diff hunks and the lines that were added or modified.
+func parseDiffFiles(diff string) []*changedFile {
+	files := []*changedFile{}
+
+	var (
+		curr    *changedFile
+		inHunk  bool
+		hunk    int
+		newLine int
+	)
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git"):
+			curr = &changedFile{Changed: map[int]bool{}}
+			inHunk = false
+			hunk = -1
+			if parts := strings.Fields(line); len(parts) > 3 {
+				curr.OldPath = strings.TrimPrefix(parts[2], "a/")
+				curr.Path = strings.TrimPrefix(parts[3], "b/")
+			}
+			files = append(files, curr)
+		case curr == nil:
+			continue
+		case strings.HasPrefix(line, "@@"):
+			// The header of the first hunk isn't a position, the headers of the rest are
+			hunk++
+			if inHunk {
+				curr.Positions = append(curr.Positions, diffPosition{Hunk: hunk})
+			}
+			inHunk = true
+			match := hunkHeaderRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			start, _ := strconv.Atoi(match[1])
+			count := 1
+			if match[2] != "" {
+				count, _ = strconv.Atoi(match[2])
+			}
+			newLine = start
+			if count > 0 {
+				curr.Hunks = append(curr.Hunks, lineRange{Start: start, End: start + count - 1})
+			}
+		case !inHunk:
+			// The file headers, these only appear before the first hunk of a file.
+			if line == "+++ /dev/null" {
+				curr.Deleted = true
+			} else if strings.HasPrefix(line, "+++ b/") {
+				curr.Path = strings.TrimPrefix(line, "+++ b/")
+			}
+		case strings.HasPrefix(line, "+"):
+			curr.Changed[newLine] = true
+			curr.Positions = append(curr.Positions, diffPosition{Hunk: hunk, Line: newLine})
+			newLine++
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "\\"):
+			// Removed lines and "\ No newline at end of file" markers aren't in the new file.
+			curr.Positions = append(curr.Positions, diffPosition{Hunk: hunk})
+		default:
+			curr.Positions = append(curr.Positions, diffPosition{Hunk: hunk, Line: newLine})
+			newLine++
+		}
+	}
+
+	return files
+}
+
+// Get the contents of the files changed by the diff at the given ref (the head SHA of the
+// pull request). Deleted files are skipped. Fetching file contents is best effort, files
+// that can't be retrieved are left out rather than failing the review.
+func getChangedFileContents(ctx context.Context, owner, repo, ref, diff string, gh *github.Client) []*fileContent {
+	contents := []*fileContent{}
+	for _, file := range parseDiffFiles(diff) {
+		if file.Deleted || file.Path == "" {
+			continue
+		}
+
+		content, err := getFileContent(ctx, owner, repo, ref, file.Path, gh)
+		if err != nil {
+			continue
+		}
+
+		contents = append(contents, &fileContent{
+			changedFile: file,
+			Lines:       strings.Split(strings.TrimSuffix(content, "\n"), "\n"),
+		})
+	}
+	return contents
+}
+
+// Get the contents of a single file at the given ref.
+func getFileContent(ctx context.Context, owner, repo, ref, path string, gh *github.Client) (string, error) {
+	var file *github.RepositoryContent
+	err := retryRateLimit(func() (err error) {
+		file, _, _, err = gh.Repositories.GetContents(
+			ctx,
+			owner,
+			repo,
+			path,
+			&github.RepositoryContentGetOptions{Ref: ref},
+		)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if file == nil {
+		return "", fmt.Errorf("%s is not a file", path)
+	}
+
+	// The contents API doesn't return the content of files larger than 1MB, those
+	// have to be fetched from the blob API instead.
+	if file.GetEncoding() == "none" {
+		var blob []byte
+		err := retryRateLimit(func() (err error) {
+			blob, _, err = gh.Git.GetBlobRaw(ctx, owner, repo, file.GetSHA())
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return string(blob), nil
+	}
+
+	return file.GetContent()
+}
+
+// Build a prompt snippet with the contents of the changed files. Whole files are included
+// if they all fit within the token budget. Otherwise each file is reduced to the regions
+// surrounding its hunks and files are added until the budget is spent. The files are
+// formatted like:
+//
+//	File: path/to/file.go
+//	   1  unchanged line
+//	>  2  added or modified line
+//	...
+func formatFileContext(files []*fileContent, budget int) string {
+	whole := make([]string, len(files))
+	total := 0
+	for i, file := range files {
+		whole[i] = formatFile(file, []lineRange{{Start: 1, End: len(file.Lines)}})
+		total += estimateTokens(whole[i])
+	}
+	if total <= budget {
+		return formatFileContextSnippets(whole)
+	}
+
+	result := []string{}
+	remaining := budget
+	for i, file := range files {
+		snippet := whole[i]
+		if estimateTokens(snippet) > remaining {
+			snippet = formatFile(file, expandRanges(file.Hunks, fileContextLines, len(file.Lines)))
+		}
+		if tokens := estimateTokens(snippet); tokens <= remaining {
+			result = append(result, snippet)
+			remaining -= tokens
+		}
+	}
+
+	return formatFileContextSnippets(result)
+}
+
+func formatFileContextSnippets(snippets []string) string {
+	if len(snippets) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(fileContextPrompt, strings.Join(snippets, "\n"))
+}
+
+// Format the given line ranges of a file with line numbers, marking changed lines with ">".
+// Gaps between the ranges are shown as "...".
+func formatFile(file *fileContent, ranges []lineRange) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("File: %s\n", file.Path))
+
+	prevEnd := 0
+	for _, r := range ranges {
+		if r.Start > prevEnd+1 {
+			sb.WriteString("...\n")
+		}
+		for n := r.Start; n <= r.End && n <= len(file.Lines); n++ {
+			marker := " "
+			if file.Changed[n] {
+				marker = ">"
+			}
+			sb.WriteString(fmt.Sprintf("%s%4d  %s\n", marker, n, file.Lines[n-1]))
+		}
+		prevEnd = r.End
+	}
+	if prevEnd < len(file.Lines) {
+		sb.WriteString("...\n")
+	}
+
+	return sb.String()
+}
+
+// Grow each range by the given number of lines in both directions, clamped to the
+// length of the file, and merge the ranges that overlap.
+func expandRanges(ranges []lineRange, by, length int) []lineRange {
+	expanded := make([]lineRange, 0, len(ranges))
+	for _, r := range ranges {
+		expanded = append(expanded, lineRange{
+			Start: max(1, r.Start-by),
+			End:   min(length, r.End+by),
+		})
+	}
+	sort.Slice(expanded, func(i, j int) bool { return expanded[i].Start < expanded[j].Start })
+
+	merged := []lineRange{}
+	for _, r := range expanded {
+		if last := len(merged) - 1; last >= 0 && r.Start <= merged[last].End+1 {
+			merged[last].End = max(merged[last].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// A rough estimate of the number of tokens in a string. Most tokenizers average around
+// four characters per token for code and english text.
+func estimateTokens(s string) int {
+	return len(s) / 4
+}
@@ -0,0 +1,121 @@
+package nit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiffFiles(t *testing.T) {
+	t.Run("should parse the changed lines and hunk ranges of each file", func(t *testing.T) {
+		diff := "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3\n@@ -10,3 +11,2 @@\nline 11\n-remove line 12\n\\ No newline at end of file\ndiff --git a/other.txt b/other.txt\ndeleted file mode 100644\n--- a/other.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-deleted line 1"
+
+		got := parseDiffFiles(diff)
+
+		assert.Equal(t, []*changedFile{
+			{
+				Path:    "file.txt",
+				OldPath: "file.txt",
+				Hunks:   []lineRange{{Start: 1, End: 3}, {Start: 11, End: 12}},
+				Changed: map[int]bool{2: true, 3: true},
+				Positions: []diffPosition{
+					{Hunk: 0, Line: 1},
+					{Hunk: 0},
+					{Hunk: 0, Line: 2},
+					{Hunk: 0, Line: 3},
+					{Hunk: 1},
+					{Hunk: 1, Line: 11},
+					{Hunk: 1},
+					{Hunk: 1},
+				},
+			},
+			{
+				Path:      "other.txt",
+				OldPath:   "other.txt",
+				Deleted:   true,
+				Changed:   map[int]bool{},
+				Positions: []diffPosition{{Hunk: 0}},
+			},
+		}, got)
+	})
+
+	t.Run("should use the new path of renamed files", func(t *testing.T) {
+		diff := "diff --git a/file.txt b/fileNew.txt\nsimilarity index 50%\nrename from file.txt\nrename to fileNew.txt\n--- a/file.txt\n+++ b/fileNew.txt\n@@ -1,2 +1,2 @@\nline 1\n+add line 2\n-deleted line 3"
+
+		got := parseDiffFiles(diff)
+
+		assert.Len(t, got, 1)
+		assert.Equal(t, "fileNew.txt", got[0].Path)
+		assert.Equal(t, "file.txt", got[0].OldPath)
+		assert.Equal(t, map[int]bool{2: true}, got[0].Changed)
+	})
+}
+
+func TestFormatFileContext(t *testing.T) {
+	lines := func(n int) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = "line"
+		}
+		return result
+	}
+
+	t.Run("should include whole files and mark the changed lines when they fit the budget", func(t *testing.T) {
+		files := []*fileContent{
+			{
+				changedFile: &changedFile{
+					Path:    "file.txt",
+					Hunks:   []lineRange{{Start: 1, End: 3}},
+					Changed: map[int]bool{2: true},
+				},
+				Lines: []string{"line 1", "new line 2", "line 3"},
+			},
+		}
+
+		want := fmt.Sprintf(fileContextPrompt, "File: file.txt\n    1  line 1\n>   2  new line 2\n    3  line 3\n")
+		got := formatFileContext(files, 1000)
+
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("should only include the regions around the hunks when whole files don't fit the budget", func(t *testing.T) {
+		files := []*fileContent{
+			{
+				changedFile: &changedFile{
+					Path:    "file.txt",
+					Hunks:   []lineRange{{Start: 100, End: 100}},
+					Changed: map[int]bool{100: true},
+				},
+				Lines: lines(200),
+			},
+		}
+
+		got := formatFileContext(files, 500)
+
+		assert.Contains(t, got, "> 100  line\n")
+		assert.Contains(t, got, "  70  line\n")
+		assert.Contains(t, got, " 130  line\n")
+		assert.NotContains(t, got, "  69  line\n")
+		assert.NotContains(t, got, " 131  line\n")
+		assert.Contains(t, got, "File: file.txt\n...\n")
+	})
+
+	t.Run("should leave out files once the budget is spent", func(t *testing.T) {
+		files := []*fileContent{
+			{
+				changedFile: &changedFile{Path: "big.txt", Hunks: []lineRange{{Start: 1, End: 200}}, Changed: map[int]bool{}},
+				Lines:       lines(200),
+			},
+			{
+				changedFile: &changedFile{Path: "small.txt", Hunks: []lineRange{{Start: 1, End: 1}}, Changed: map[int]bool{}},
+				Lines:       lines(1),
+			},
+		}
+
+		got := formatFileContext(files, 100)
+
+		assert.NotContains(t, got, "big.txt")
+		assert.Contains(t, got, "small.txt")
+	})
+}
@@ -0,0 +1,59 @@
+package nit
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// A request to the GitHub GraphQL API.
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// A response from the GitHub GraphQL API. Errors can be returned alongside (partial) data.
+type graphQLResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Run a query or mutation against the GitHub GraphQL API, decoding the "data" of the
+// response into result. The REST client is used so requests share its authentication.
+//
+// see https://docs.github.com/en/graphql/guides/forming-calls-with-graphql
+func queryGraphQL(ctx context.Context, gh *github.Client, query string, variables map[string]interface{}, result interface{}) error {
+	// The GraphQL endpoint of GitHub Enterprise Server is /api/graphql rather than
+	// /api/v3/graphql
+	endpoint := "graphql"
+	if strings.HasSuffix(gh.BaseURL.Path, "/api/v3/") {
+		endpoint = "../graphql"
+	}
+
+	resp := &graphQLResponse{Data: result}
+	err := retryRateLimit(func() error {
+		// The request body can only be read once so a retry needs a new request
+		req, err := gh.NewRequest("POST", endpoint, &graphQLRequest{Query: query, Variables: variables})
+		if err != nil {
+			return err
+		}
+		_, err = gh.Do(ctx, req, resp)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Errors) > 0 {
+		messages := []string{}
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return errors.New("graphql: " + strings.Join(messages, "; "))
+	}
+	return nil
+}
@@ -2,15 +2,20 @@ package config
 
 import (
 	"strings"
+	"time"
 
+	"github.com/evanmcneely/nit"
 	"github.com/spf13/viper"
 )
 
 type (
 	// Config stores complete configuration
 	Config struct {
-		App    AppConfig
-		Review ReviewConfig
+		App     AppConfig
+		Server  ServerConfig
+		Review  ReviewConfig
+		Log     LogConfig
+		Tracing TracingConfig
 	}
 
 	// AppConfig stores application configuration
@@ -21,12 +26,80 @@ type (
 		OpenaiKey     string
 		AnthropicKey  string
 		GithubToken   string
+		// The path of the SQLite database that review runs are recorded in, runs are kept
+		// in memory when it is empty
+		Database string
+		// The token that authenticates requests to the admin API and dashboard, they are
+		// turned off when it is empty
+		AdminToken string
+	}
+
+	// Stores HTTP server configuration, durations are strings such as "30s"
+	ServerConfig struct {
+		// The longest time to read a request
+		ReadTimeout time.Duration
+		// The longest time to handle a request and write the response
+		WriteTimeout time.Duration
+		// The longest time to wait for in-flight requests and reviews when shutting down,
+		// there is no limit when it is 0
+		ShutdownTimeout time.Duration
+		// The longest time each readiness check can take
+		ReadyTimeout time.Duration
+		// The URLs checked for GitHub and the AI provider being reachable, the checks are
+		// skipped when they are empty
+		GithubURL   string
+		ProviderURL string
+	}
+
+	// Stores logging configuration
+	LogConfig struct {
+		// The lowest level logged: debug, info, warn or error
+		Level string
+		// How log lines are written: text or json
+		Format string
+		// Whether prompts and completions are left out of the logs
+		Redact bool
+	}
+
+	// Stores tracing configuration
+	TracingConfig struct {
+		// The host and port of the OTLP/HTTP collector that spans are exported to, tracing
+		// is turned off when it is empty
+		Endpoint string
+		// Whether spans are exported over plain HTTP instead of HTTPS
+		Insecure bool
 	}
 
 	// Stores review specific data
 	ReviewConfig struct {
 		OptIn bool
 		Name  string
+		// The most pages of results retrieved from GitHub list endpoints
+		MaxPages int
+		// The default settings for every repository
+		RepoConfig `mapstructure:",squash"`
+		// Settings for specific repositories keyed by "owner/repo"
+		Repos map[string]RepoConfig
+		// Where dry runs are written: log, store or dir
+		DryRunOutput string
+		// The directory dry runs are written to when the output is dir
+		DryRunDir string
+	}
+
+	// Stores the review settings that can be configured per repository
+	RepoConfig struct {
+		// How generated pull request descriptions are published: off, comment or update
+		Describe string
+		// The lowest severity of comments posted inline: nit, minor, major or blocker
+		InlineSeverity string
+		// The lowest severity of comments summarized in the review body, lower ones are dropped
+		SummarySeverity string
+		// Whether review comments are checked by a model before posting: off, cheap or good
+		Critique string
+		// Which events reviews are posted with: approve, never-approve, comment or request-changes
+		ReviewPolicy string
+		// Whether anything is written to pull requests or it is only generated: off or on
+		DryRun string
 	}
 )
 
@@ -39,6 +112,8 @@ func GetConfig() (Config, error) {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("internal/config")
+	// So that the CLI can be run outside of this repository
+	viper.AddConfigPath("$HOME/.config/nit")
 
 	// Load env variables
 	viper.SetEnvPrefix("nit")
@@ -55,3 +130,31 @@ func GetConfig() (Config, error) {
 
 	return c, nil
 }
+
+// The review settings as the nit config, with the settings of each repository. Settings
+// that only the server uses, such as where dry runs are written, are left to the server.
+func (c ReviewConfig) NitConfig() *nit.Config {
+	config := &nit.Config{
+		OptIn:      c.OptIn,
+		AppName:    c.Name,
+		MaxPages:   c.MaxPages,
+		RepoConfig: c.RepoConfig.NitConfig(),
+		Repos:      map[string]nit.RepoConfig{},
+	}
+	for name, repo := range c.Repos {
+		config.Repos[name] = repo.NitConfig()
+	}
+	return config
+}
+
+// The settings of a repository as the nit config
+func (c RepoConfig) NitConfig() nit.RepoConfig {
+	return nit.RepoConfig{
+		Describe:        c.Describe,
+		InlineSeverity:  c.InlineSeverity,
+		SummarySeverity: c.SummarySeverity,
+		Critique:        c.Critique,
+		ReviewPolicy:    c.ReviewPolicy,
+		DryRun:          c.DryRun,
+	}
+}
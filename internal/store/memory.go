@@ -0,0 +1,62 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// A store that keeps the review runs in memory, they are lost when the app stops.
+type MemoryStore struct {
+	mu   sync.Mutex
+	runs []*ReviewRun
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) SaveRun(run *ReviewRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.ID = int64(len(s.runs) + 1)
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+	saved := *run
+	saved.CommentIDs = append([]int64{}, run.CommentIDs...)
+	s.runs = append(s.runs, &saved)
+	return nil
+}
+
+func (s *MemoryStore) GetRun(id int64) (*ReviewRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > int64(len(s.runs)) {
+		return nil, ErrNotFound
+	}
+	run := *s.runs[id-1]
+	return &run, nil
+}
+
+func (s *MemoryStore) ListRuns(filter RunFilter) ([]*ReviewRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []*ReviewRun{}
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(runs) >= filter.Limit {
+			break
+		}
+		if filter.matches(s.runs[i]) {
+			run := *s.runs[i]
+			runs = append(runs, &run)
+		}
+	}
+	return runs, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
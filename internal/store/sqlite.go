@@ -0,0 +1,198 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS review_runs (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner          TEXT    NOT NULL,
+	repo           TEXT    NOT NULL,
+	number         INTEGER NOT NULL,
+	head_sha       TEXT    NOT NULL,
+	prompt_version TEXT    NOT NULL,
+	model          TEXT    NOT NULL,
+	tokens         INTEGER NOT NULL,
+	latency_ms     INTEGER NOT NULL,
+	review_id      INTEGER NOT NULL,
+	comment_ids    TEXT    NOT NULL,
+	dropped        INTEGER NOT NULL,
+	duplicates     INTEGER NOT NULL,
+	outcome        TEXT    NOT NULL,
+	error          TEXT    NOT NULL,
+	payload        TEXT    NOT NULL,
+	created_at     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS review_runs_pull_request ON review_runs (owner, repo, number);`
+
+const reviewRunColumns = `id, owner, repo, number, head_sha, prompt_version, model, tokens, latency_ms, review_id, comment_ids, dropped, duplicates, outcome, error, payload, created_at`
+
+// A store that keeps the review runs in a SQLite database file.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// Open (or create) the SQLite database at the path. Use ":memory:" for a database that
+// only lives as long as the store.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time and every connection to ":memory:" is a
+	// different database
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) SaveRun(run *ReviewRun) error {
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+	commentIDs, err := json.Marshal(append([]int64{}, run.CommentIDs...))
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`INSERT INTO review_runs (owner, repo, number, head_sha, prompt_version, model, tokens, latency_ms, review_id, comment_ids, dropped, duplicates, outcome, error, payload, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Owner,
+		run.Repo,
+		run.Number,
+		run.HeadSHA,
+		run.PromptVersion,
+		run.Model,
+		run.Tokens,
+		run.Latency.Milliseconds(),
+		run.ReviewID,
+		string(commentIDs),
+		run.Dropped,
+		run.Duplicates,
+		run.Outcome,
+		run.Error,
+		run.Payload,
+		run.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return err
+	}
+
+	run.ID, err = result.LastInsertId()
+	return err
+}
+
+func (s *SQLiteStore) GetRun(id int64) (*ReviewRun, error) {
+	row := s.db.QueryRow(`SELECT `+reviewRunColumns+` FROM review_runs WHERE id = ?`, id)
+	run, err := scanReviewRun(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return run, err
+}
+
+func (s *SQLiteStore) ListRuns(filter RunFilter) ([]*ReviewRun, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.Owner != "" {
+		conditions = append(conditions, "owner = ?")
+		args = append(args, filter.Owner)
+	}
+	if filter.Repo != "" {
+		conditions = append(conditions, "repo = ?")
+		args = append(args, filter.Repo)
+	}
+	if filter.Number != 0 {
+		conditions = append(conditions, "number = ?")
+		args = append(args, filter.Number)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+
+	query := `SELECT ` + reviewRunColumns + ` FROM review_runs`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*ReviewRun{}
+	for rows.Next() {
+		run, err := scanReviewRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// A row of a query result, either *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan a row selected with reviewRunColumns.
+func scanReviewRun(row rowScanner) (*ReviewRun, error) {
+	var (
+		run        ReviewRun
+		latency    int64
+		commentIDs string
+		createdAt  int64
+	)
+	err := row.Scan(
+		&run.ID,
+		&run.Owner,
+		&run.Repo,
+		&run.Number,
+		&run.HeadSHA,
+		&run.PromptVersion,
+		&run.Model,
+		&run.Tokens,
+		&latency,
+		&run.ReviewID,
+		&commentIDs,
+		&run.Dropped,
+		&run.Duplicates,
+		&run.Outcome,
+		&run.Error,
+		&run.Payload,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Latency = time.Duration(latency) * time.Millisecond
+	run.CreatedAt = time.UnixMilli(createdAt)
+	if err := json.Unmarshal([]byte(commentIDs), &run.CommentIDs); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
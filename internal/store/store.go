@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// The outcomes of a review run.
+const (
+	// The review was posted to the pull request
+	OutcomePosted = "posted"
+	// Something went wrong before the review could be posted
+	OutcomeFailed = "failed"
+	// The review (or reply) was generated in a dry run and not posted
+	OutcomeDryRun = "dry-run"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// A single attempt at reviewing a pull request.
+type ReviewRun struct {
+	// Assigned by the store when the run is saved
+	ID      int64  `json:"id"`
+	Owner   string `json:"owner"`
+	Repo    string `json:"repo"`
+	Number  int    `json:"number"`
+	HeadSHA string `json:"head_sha"`
+	// The version of the prompts used to generate the review
+	PromptVersion string `json:"prompt_version"`
+	// The model that generated the review comments
+	Model   string        `json:"model"`
+	Tokens  int           `json:"tokens"`
+	Latency time.Duration `json:"latency_ns"`
+	// The ID of the posted review, 0 when nothing was posted
+	ReviewID int64 `json:"review_id"`
+	// The IDs of the comments posted with the review
+	CommentIDs []int64 `json:"comment_ids"`
+	// The number of generated comments dropped by the self-critique pass and as duplicates
+	Dropped    int    `json:"dropped"`
+	Duplicates int    `json:"duplicates"`
+	Outcome    string `json:"outcome"`
+	// The error message of a failed run
+	Error string `json:"error,omitempty"`
+	// The review or reply that would have been posted by a dry run, as JSON
+	Payload string `json:"payload,omitempty"`
+	// Assigned by the store when the run is saved if it isn't set
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Which review runs to list. Zero values match everything.
+type RunFilter struct {
+	Owner   string
+	Repo    string
+	Number  int
+	Outcome string
+	// The most runs to return, all of them when 0
+	Limit int
+}
+
+// Stores the review runs of the app. Runs are listed newest first.
+type Store interface {
+	SaveRun(run *ReviewRun) error
+	// Returns ErrNotFound when there is no run with the ID
+	GetRun(id int64) (*ReviewRun, error)
+	ListRuns(filter RunFilter) ([]*ReviewRun, error)
+	Close() error
+}
+
+func (f RunFilter) matches(run *ReviewRun) bool {
+	return (f.Owner == "" || f.Owner == run.Owner) &&
+		(f.Repo == "" || f.Repo == run.Repo) &&
+		(f.Number == 0 || f.Number == run.Number) &&
+		(f.Outcome == "" || f.Outcome == run.Outcome)
+}
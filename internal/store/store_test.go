@@ -0,0 +1,99 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"sqlite": func(t *testing.T) Store {
+			s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "nit.db"))
+			assert.Nil(t, err)
+			return s
+		},
+	}
+
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	createRun := func(repo string, number int, outcome string) *ReviewRun {
+		return &ReviewRun{
+			Owner:         "owner",
+			Repo:          repo,
+			Number:        number,
+			HeadSHA:       "abc123",
+			PromptVersion: "1",
+			Model:         "gpt-4",
+			Tokens:        100,
+			Latency:       1500 * time.Millisecond,
+			ReviewID:      10,
+			CommentIDs:    []int64{11, 12},
+			Dropped:       2,
+			Duplicates:    1,
+			Outcome:       outcome,
+			Payload:       `{"body": "review"}`,
+			CreatedAt:     createdAt,
+		}
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("should save and get a run", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+
+				run := createRun("repo", 1, OutcomePosted)
+				assert.Nil(t, s.SaveRun(run))
+				assert.NotZero(t, run.ID)
+
+				got, err := s.GetRun(run.ID)
+				assert.Nil(t, err)
+				assert.Equal(t, run.ID, got.ID)
+				assert.Equal(t, []int64{11, 12}, got.CommentIDs)
+				assert.Equal(t, 1500*time.Millisecond, got.Latency)
+				assert.True(t, createdAt.Equal(got.CreatedAt))
+				got.CreatedAt = run.CreatedAt
+				assert.Equal(t, run, got)
+			})
+
+			t.Run("should return not found for unknown runs", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+
+				_, err := s.GetRun(42)
+				assert.ErrorIs(t, err, ErrNotFound)
+			})
+
+			t.Run("should list matching runs newest first", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+
+				failed := createRun("repo", 1, OutcomeFailed)
+				failed.Error = "something happened"
+				assert.Nil(t, s.SaveRun(failed))
+				assert.Nil(t, s.SaveRun(createRun("repo", 1, OutcomePosted)))
+				assert.Nil(t, s.SaveRun(createRun("other", 2, OutcomePosted)))
+
+				runs, err := s.ListRuns(RunFilter{})
+				assert.Nil(t, err)
+				assert.Len(t, runs, 3)
+				assert.Equal(t, "other", runs[0].Repo)
+
+				runs, err = s.ListRuns(RunFilter{Repo: "repo", Number: 1, Limit: 1})
+				assert.Nil(t, err)
+				assert.Len(t, runs, 1)
+				assert.Equal(t, OutcomePosted, runs[0].Outcome)
+
+				runs, err = s.ListRuns(RunFilter{Outcome: OutcomeFailed})
+				assert.Nil(t, err)
+				assert.Len(t, runs, 1)
+				assert.Equal(t, "something happened", runs[0].Error)
+			})
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// Review a diff without GitHub, such as the changes in a local checkout. readFile gets
+// the new version of a changed file, files it returns an error for are reviewed from the
+// diff alone. The files are added to the details.
+func ReviewDiff(ctx context.Context, details *PullRequestDetails, diff string, readFile func(path string) (string, error), config RepoConfig, ai *AI) (*github.PullRequestReviewRequest, *ReviewStats, error) {
+	details.Files = map[string]string{}
+	files := []*fileContent{}
+	for _, file := range parseDiffFiles(diff) {
+		if file.Deleted || file.Path == "" {
+			continue
+		}
+
+		content, err := readFile(file.Path)
+		if err != nil {
+			continue
+		}
+
+		details.Files[file.Path] = content
+		files = append(files, &fileContent{
+			changedFile: file,
+			Lines:       strings.Split(strings.TrimSuffix(content, "\n"), "\n"),
+		})
+	}
+
+	return ai.GeneratePullRequestReview(ctx, details, diff, formatFileContext(files, fileContextTokenBudget), config)
+}
+
+// A review comment placed on the lines of a file.
+type Annotation struct {
+	Path string `json:"path"`
+	// The first line of a comment on multiple lines, 0 for a single line
+	StartLine int `json:"start_line,omitempty"`
+	// The line of the comment in the new version of the file, 0 when it is on a removed
+	// line or a line that isn't in the diff
+	Line int    `json:"line"`
+	Body string `json:"body"`
+}
+
+// Place the comments of a review on the lines of the files in the diff it was generated
+// for. Comments left on a diff position are placed on the line at that position.
+func AnnotateReview(diff string, review *github.PullRequestReviewRequest) []*Annotation {
+	files := parseDiffFiles(diff)
+
+	annotations := []*Annotation{}
+	for _, comment := range review.Comments {
+		annotation := &Annotation{
+			Path:      comment.GetPath(),
+			StartLine: comment.GetStartLine(),
+			Line:      comment.GetLine(),
+			Body:      comment.GetBody(),
+		}
+
+		file := findChangedFile(files, comment.GetPath())
+		if comment.Position != nil && file != nil {
+			if position := comment.GetPosition(); position >= 1 && position <= len(file.Positions) {
+				annotation.Line = file.Positions[position-1].Line
+			}
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// Render a review the way it would look on GitHub: the event and body followed by each
+// comment under the file and lines (or diff position) it is on.
+func FormatReviewMarkdown(review *github.PullRequestReviewRequest) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Event:** %s\n\n", review.GetEvent()))
+	sb.WriteString(review.GetBody() + "\n")
+	if len(review.Comments) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString("\n## Comments\n")
+	for _, comment := range review.Comments {
+		location := fmt.Sprintf("position %d", comment.GetPosition())
+		if comment.Line != nil {
+			location = fmt.Sprintf("line %d", comment.GetLine())
+			if comment.StartLine != nil {
+				location = fmt.Sprintf("lines %d-%d", comment.GetStartLine(), comment.GetLine())
+			}
+		}
+		sb.WriteString(fmt.Sprintf("\n### `%s` %s\n\n%s\n", comment.GetPath(), location, comment.GetBody()))
+	}
+	return sb.String()
+}
@@ -0,0 +1,108 @@
+package nit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviewDiff(t *testing.T) {
+	diff := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3\n" +
+		"diff --git a/gone.txt b/gone.txt\ndeleted file mode 100644\n--- a/gone.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-gone\n"
+	payload := `{"body": "Looks fine", "event": "COMMENT", "comments": [{"path": "file.txt", "position": 3, "body": "Name this better"}]}`
+
+	t.Run("should review the diff with the files that could be read", func(t *testing.T) {
+		responses := []string{"notes", payload}
+		mockProvider := &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				r := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: r, Tokens: 10}, nil
+			},
+		}
+
+		read := []string{}
+		readFile := func(path string) (string, error) {
+			read = append(read, path)
+			return "line 1\nnew line 2\nadd line 3\n", nil
+		}
+
+		details := &PullRequestDetails{Title: "Rename things"}
+		review, stats, err := ReviewDiff(context.Background(), details, diff, readFile, RepoConfig{}, NewAI(mockProvider, mockProvider))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"file.txt"}, read)
+		assert.Equal(t, map[string]string{"file.txt": "line 1\nnew line 2\nadd line 3\n"}, details.Files)
+		assert.Equal(t, "COMMENT", review.GetEvent())
+		assert.Len(t, review.Comments, 1)
+		assert.Equal(t, 20, stats.Tokens)
+		assert.Contains(t, mockProvider.calls.CreateCompletetion[0].Req.Prompt, "add line 3")
+	})
+
+	t.Run("should review the diff alone when the files can't be read", func(t *testing.T) {
+		responses := []string{"notes", payload}
+		mockProvider := &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				r := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: r, Tokens: 10}, nil
+			},
+		}
+		readFile := func(path string) (string, error) {
+			return "", errors.New("not found")
+		}
+
+		details := &PullRequestDetails{}
+		review, _, err := ReviewDiff(context.Background(), details, diff, readFile, RepoConfig{}, NewAI(mockProvider, mockProvider))
+
+		assert.NoError(t, err)
+		assert.Empty(t, details.Files)
+		assert.Len(t, review.Comments, 1)
+	})
+}
+
+func TestAnnotateReview(t *testing.T) {
+	diff := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3\n"
+
+	t.Run("should place comments on the lines of their diff positions", func(t *testing.T) {
+		review := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("file.txt"), Position: github.Int(4), Body: github.String("added")},
+				{Path: github.String("file.txt"), Position: github.Int(2), Body: github.String("removed")},
+				{Path: github.String("file.txt"), StartLine: github.Int(2), Line: github.Int(3), Body: github.String("lines")},
+				{Path: github.String("other.txt"), Position: github.Int(1), Body: github.String("unknown")},
+			},
+		}
+
+		expected := []*Annotation{
+			{Path: "file.txt", Line: 3, Body: "added"},
+			{Path: "file.txt", Line: 0, Body: "removed"},
+			{Path: "file.txt", StartLine: 2, Line: 3, Body: "lines"},
+			{Path: "other.txt", Line: 0, Body: "unknown"},
+		}
+		assert.Equal(t, expected, AnnotateReview(diff, review))
+	})
+}
+
+func TestFormatReviewMarkdown(t *testing.T) {
+	t.Run("should render a review without comments", func(t *testing.T) {
+		review := &github.PullRequestReviewRequest{Body: github.String("Looks good"), Event: github.String("APPROVE")}
+
+		assert.Equal(t, "**Event:** APPROVE\n\nLooks good\n", FormatReviewMarkdown(review))
+	})
+
+	t.Run("should render each comment with its location", func(t *testing.T) {
+		review := &github.PullRequestReviewRequest{
+			Body:  github.String("Some notes"),
+			Event: github.String("COMMENT"),
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("a.go"), Line: github.Int(7), Body: github.String("first")},
+			},
+		}
+
+		assert.Equal(t, "**Event:** COMMENT\n\nSome notes\n\n## Comments\n\n### `a.go` line 7\n\nfirst\n", FormatReviewMarkdown(review))
+	})
+}
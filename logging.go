@@ -0,0 +1,64 @@
+package nit
+
+import (
+	"log/slog"
+	"time"
+)
+
+// The stages of handling an event, logged under the "stage" key.
+const (
+	stageCompletion = "completion"
+	stageCritique   = "critique"
+	stageDescribe   = "describe"
+	stageReview     = "review"
+	stageReply      = "reply"
+	stageMention    = "mention"
+	stageDismiss    = "dismiss"
+	stageResolve    = "resolve"
+)
+
+// Returns a copy of the AI that logs to the logger. Use it to add fields (such as the
+// webhook delivery ID, repository and pull request number) to everything logged while
+// handling an event.
+func (ai *AI) WithLogger(logger *slog.Logger) *AI {
+	clone := *ai
+	clone.logger = logger
+	return &clone
+}
+
+// The logger of the AI, the default logger when none is set.
+func (ai *AI) Logger() *slog.Logger {
+	if ai.logger == nil {
+		return slog.Default()
+	}
+	return ai.logger
+}
+
+// Log a completion at debug level. The prompt and completion are left out when content
+// is redacted.
+func (c *completion) log(prompt string, resp *CompletionResponse, elapsed time.Duration, err error) {
+	attrs := []any{
+		"stage", stageCompletion,
+		"provider", providerName(c.provider),
+		"tier", c.tier(),
+		"format", c.format,
+		"duration", elapsed,
+	}
+	if resp != nil {
+		attrs = append(attrs, "model", resp.Model, "tokens", resp.Tokens)
+	}
+	if c.ai.RedactContent {
+		attrs = append(attrs, "prompt_length", len(prompt))
+	} else {
+		attrs = append(attrs, "prompt", prompt)
+		if resp != nil {
+			attrs = append(attrs, "completion", resp.Completion)
+		}
+	}
+
+	if err != nil {
+		c.ai.Logger().Warn("completion failed", append(attrs, "error", err)...)
+		return
+	}
+	c.ai.Logger().Debug("created completion", attrs...)
+}
@@ -0,0 +1,54 @@
+package nit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionLogging(t *testing.T) {
+	newAI := func(buf *bytes.Buffer, redact bool) *AI {
+		provider := &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "secret completion", Tokens: 3, Model: "test-model"}, nil
+			},
+		}
+		ai := NewAI(provider, provider)
+		ai.RedactContent = redact
+		logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		return ai.WithLogger(logger.With("delivery", "abc"))
+	}
+
+	t.Run("should log completions with the fields of the logger", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := newAI(&buf, false).NewCompletion().Cheap().Create(context.Background(), "secret prompt")
+		assert.NoError(t, err)
+
+		assert.Contains(t, buf.String(), `"delivery":"abc"`)
+		assert.Contains(t, buf.String(), `"stage":"completion"`)
+		assert.Contains(t, buf.String(), `"model":"test-model"`)
+		assert.Contains(t, buf.String(), `"tier":"cheap"`)
+		assert.Contains(t, buf.String(), "secret prompt")
+		assert.Contains(t, buf.String(), "secret completion")
+	})
+
+	t.Run("should leave prompts and completions out when content is redacted", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := newAI(&buf, true).NewCompletion().Create(context.Background(), "secret prompt")
+		assert.NoError(t, err)
+
+		assert.Contains(t, buf.String(), `"prompt_length":13`)
+		assert.NotContains(t, buf.String(), "secret prompt")
+		assert.NotContains(t, buf.String(), "secret completion")
+	})
+
+	t.Run("should not change the logger of the original AI", func(t *testing.T) {
+		ai := NewAI(nil, nil)
+		ai.WithLogger(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+
+		assert.Equal(t, slog.Default(), ai.Logger())
+	})
+}
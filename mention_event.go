@@ -0,0 +1,209 @@
+package nit
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// The regexp that finds mentions of each app name, compiled the first time the name is seen.
+// App names come from the config so there are only ever a few.
+var mentionRegexes sync.Map
+
+type MentionResponse struct {
+	Tokens int
+	Id     int64
+	// The reply that would have been posted when it was a dry run
+	DryRun *DryRun
+}
+
+func ShouldRespondToMention(e *github.IssueCommentEvent, c *Config) (bool, string) {
+	var (
+		author = e.GetComment().GetUser()
+		action = e.GetAction()
+		body   = e.GetComment().GetBody()
+	)
+
+	switch {
+	// ignore comments by bots and our own comments
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author.GetLogin(), "[bot]"):
+		return false, "comment made by a bot"
+	case c.UserID != 0 && author.GetID() == c.UserID:
+		return false, "comment made by our own app"
+	// we will only handle comments that are just created (not edited, deleted, etc)
+	case action != "created":
+		return false, "comment was not \"created\""
+	// conversation comments on issues are delivered with the same event
+	case !e.GetIssue().IsPullRequest():
+		return false, "comment is not on a pull request"
+	// commands are handled on their own
+	case hasCommand(body, describeCommand):
+		return false, "comment is a describe command"
+	case !isMentioned(body, c.AppName):
+		return false, "comment does not mention our app"
+	default:
+		return true, ""
+	}
+}
+
+func ShouldRespondToReviewMention(e *github.PullRequestReviewEvent, c *Config) (bool, string) {
+	var (
+		author = e.GetReview().GetUser()
+		action = e.GetAction()
+		body   = e.GetReview().GetBody()
+	)
+
+	switch {
+	// ignore reviews by bots and our own reviews
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author.GetLogin(), "[bot]"):
+		return false, "review made by a bot"
+	case c.UserID != 0 && author.GetID() == c.UserID:
+		return false, "review made by our own app"
+	case action != "submitted":
+		return false, "review was not \"submitted\""
+	case !isMentioned(body, c.AppName):
+		return false, "review does not mention our app"
+	default:
+		return true, ""
+	}
+}
+
+func RespondToMention(ctx context.Context, event *github.IssueCommentEvent, config *Config, ai *AI, gh *github.Client) (*MentionResponse, error) {
+	return respondToMention(
+		ctx,
+		event.GetRepo().GetOwner().GetLogin(),
+		event.GetRepo().GetName(),
+		&PullRequestDetails{
+			Number:      event.GetIssue().GetNumber(),
+			Title:       event.GetIssue().GetTitle(),
+			Description: event.GetIssue().GetBody(),
+		},
+		event.GetComment().GetBody(),
+		event.GetComment().GetUser().GetLogin(),
+		event.GetComment().GetID(),
+		config,
+		ai,
+		gh,
+	)
+}
+
+func RespondToReviewMention(ctx context.Context, event *github.PullRequestReviewEvent, config *Config, ai *AI, gh *github.Client) (*MentionResponse, error) {
+	return respondToMention(
+		ctx,
+		event.GetRepo().GetOwner().GetLogin(),
+		event.GetRepo().GetName(),
+		&PullRequestDetails{
+			Number:      event.GetPullRequest().GetNumber(),
+			Title:       event.GetPullRequest().GetTitle(),
+			Description: event.GetPullRequest().GetBody(),
+		},
+		event.GetReview().GetBody(),
+		event.GetReview().GetUser().GetLogin(),
+		0,
+		config,
+		ai,
+		gh,
+	)
+}
+
+// Reply to a comment mentioning the app with a comment on the pull request conversation.
+// The commentID is the ID of the conversation comment that mentions the app so that it can
+// be left out of the conversation given to the AI, 0 when the mention is in a review.
+func respondToMention(ctx context.Context, owner, repository string, details *PullRequestDetails, body, author string, commentID int64, config *Config, ai *AI, gh *github.Client) (*MentionResponse, error) {
+	var diff string
+	err := retryRateLimit(func() (err error) {
+		diff, _, err = gh.PullRequests.GetRaw(
+			ctx,
+			owner,
+			repository,
+			details.Number,
+			github.RawOptions{Type: github.Diff},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := paginate(config.MaxPages, func(opts *github.ListOptions) ([]*github.IssueComment, *github.Response, error) {
+		return gh.Issues.ListComments(
+			ctx,
+			owner,
+			repository,
+			details.Number,
+			&github.IssueListCommentsOptions{ListOptions: *opts},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conversation := []*github.IssueComment{}
+	for _, comment := range comments {
+		if comment.GetID() != commentID {
+			conversation = append(conversation, comment)
+		}
+	}
+
+	reply, err := ai.GenerateMentionReply(ctx, details, diff, body, author, conversation, config.AppName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(reply.Completion) == noreply {
+		ai.Logger().Info("not replying to mention", "stage", stageMention, "reason", noreply, "tokens", reply.Tokens)
+		return &MentionResponse{Tokens: reply.Tokens}, nil
+	}
+
+	if isDryRun(config, owner, repository, details.Description) {
+		run := &DryRun{
+			Owner:  owner,
+			Repo:   repository,
+			Number: details.Number,
+			Tokens: reply.Tokens,
+			Reply:  reply.Completion,
+		}
+		if err := writeDryRun(config, ai, stageMention, run); err != nil {
+			return &MentionResponse{Tokens: reply.Tokens}, err
+		}
+		return &MentionResponse{Tokens: reply.Tokens, DryRun: run}, nil
+	}
+
+	var comment *github.IssueComment
+	err = retryRateLimit(func() (err error) {
+		comment, _, err = gh.Issues.CreateComment(
+			ctx,
+			owner,
+			repository,
+			details.Number,
+			&github.IssueComment{Body: github.String(reply.Completion)},
+		)
+		return err
+	})
+	if err != nil {
+		return &MentionResponse{Tokens: reply.Tokens}, err
+	}
+	ai.Logger().Info("replied to mention", "stage", stageMention, "comment_id", comment.GetID(), "tokens", reply.Tokens)
+
+	return &MentionResponse{
+		Tokens: reply.Tokens,
+		Id:     comment.GetID(),
+	}, nil
+}
+
+// Check if a comment mentions the app with "@name". Mentions are case insensitive like
+// GitHub logins.
+func isMentioned(body, name string) bool {
+	if name == "" {
+		return false
+	}
+	mention, ok := mentionRegexes.Load(name)
+	if !ok {
+		mention, _ = mentionRegexes.LoadOrStore(name, regexp.MustCompile(`(?i)(^|[^\w@/-])@`+regexp.QuoteMeta(name)+`(\[bot\])?($|[^\w-])`))
+	}
+	return mention.(*regexp.Regexp).MatchString(body)
+}
@@ -0,0 +1,193 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRespondToMention(t *testing.T) {
+	config := &Config{AppName: "nit", UserID: 1}
+
+	createEvent := func(id int64, login, body string, pr bool) *github.IssueCommentEvent {
+		issue := &github.Issue{}
+		if pr {
+			issue.PullRequestLinks = &github.PullRequestLinks{}
+		}
+		return &github.IssueCommentEvent{
+			Action: github.String("created"),
+			Issue:  issue,
+			Comment: &github.IssueComment{
+				Body: github.String(body),
+				User: &github.User{ID: github.Int64(id), Login: github.String(login)},
+			},
+		}
+	}
+
+	t.Run("should respond to comments that mention the app", func(t *testing.T) {
+		ok, _ := ShouldRespondToMention(createEvent(2, "user", "what do you think @nit?", true), config)
+		assert.True(t, ok)
+	})
+
+	t.Run("should ignore comments that don't mention the app", func(t *testing.T) {
+		for _, body := range []string{"nit: rename this", "ask @nitpicker", "email me@nit.dev"} {
+			ok, _ := ShouldRespondToMention(createEvent(2, "user", body, true), config)
+			assert.False(t, ok, body)
+		}
+	})
+
+	t.Run("should ignore our own comments", func(t *testing.T) {
+		ok, _ := ShouldRespondToMention(createEvent(1, "user", "@nit", true), config)
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore comments on issues", func(t *testing.T) {
+		ok, _ := ShouldRespondToMention(createEvent(2, "user", "@nit", false), config)
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore describe commands", func(t *testing.T) {
+		ok, _ := ShouldRespondToMention(createEvent(2, "user", "@nit\n/nit describe", true), config)
+		assert.False(t, ok)
+	})
+}
+
+func TestShouldRespondToReviewMention(t *testing.T) {
+	config := &Config{AppName: "nit", UserID: 1}
+
+	createEvent := func(action string, id int64, body string) *github.PullRequestReviewEvent {
+		return &github.PullRequestReviewEvent{
+			Action: github.String(action),
+			Review: &github.PullRequestReview{
+				Body: github.String(body),
+				User: &github.User{ID: github.Int64(id), Login: github.String("user")},
+			},
+		}
+	}
+
+	t.Run("should respond to reviews that mention the app", func(t *testing.T) {
+		ok, _ := ShouldRespondToReviewMention(createEvent("submitted", 2, "@NIT can you check this?"), config)
+		assert.True(t, ok)
+	})
+
+	t.Run("should ignore our own reviews", func(t *testing.T) {
+		ok, _ := ShouldRespondToReviewMention(createEvent("submitted", 1, "@nit"), config)
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore reviews that aren't submitted", func(t *testing.T) {
+		ok, _ := ShouldRespondToReviewMention(createEvent("edited", 2, "@nit"), config)
+		assert.False(t, ok)
+	})
+}
+
+func TestRespondToMention(t *testing.T) {
+	const diff = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n+line 2"
+
+	event := &github.IssueCommentEvent{
+		Action: github.String("created"),
+		Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("user")},
+		},
+		Issue: &github.Issue{
+			Number: github.Int(123),
+			Title:  github.String("title"),
+			Body:   github.String("description"),
+		},
+		Comment: &github.IssueComment{
+			ID:   github.Int64(3),
+			Body: github.String("@nit is this safe?"),
+			User: &github.User{Login: github.String("dev")},
+		},
+	}
+	conversation := []*github.IssueComment{
+		{ID: github.Int64(1), Body: github.String("Looks good"), User: &github.User{Login: github.String("reviewer")}},
+		{ID: github.Int64(3), Body: github.String("@nit is this safe?"), User: &github.User{Login: github.String("dev")}},
+	}
+
+	setupGithubMock := func(posted *github.IssueComment) *github.Client {
+		return github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatch(ghMock.GetReposIssuesCommentsByOwnerByRepoByIssueNumber, conversation),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(posted)
+					w.Write([]byte(`{"id": 4}`))
+				}),
+			),
+		))
+	}
+
+	t.Run("should reply to the mention on the pull request conversation", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "Yes, the input is validated.", Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		var posted github.IssueComment
+
+		res, err := RespondToMention(context.Background(), event, &Config{AppName: "nit"}, mockAI, setupGithubMock(&posted))
+
+		assert.Nil(t, err)
+		assert.Equal(t, &MentionResponse{Tokens: 10, Id: 4}, res)
+		assert.Equal(t, "Yes, the input is validated.", posted.GetBody())
+
+		details := &PullRequestDetails{Number: 123, Title: "title", Description: "description"}
+		want := &CompletionRequest{
+			Prompt: fmt.Sprintf(mentionReplyPrompt, formatPullRequestDetails(details), diff, "nit", "reviewer: Looks good\n", "dev", "@nit is this safe?"),
+			Model:  modelGood,
+			Format: formatText,
+		}
+		assert.Equal(t, want, mockProvider.calls.CreateCompletetion[0].Req)
+	})
+
+	t.Run("should not reply if the model doesn't want to", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: noreply, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		var posted github.IssueComment
+
+		res, err := RespondToMention(context.Background(), event, &Config{AppName: "nit"}, mockAI, setupGithubMock(&posted))
+
+		assert.Nil(t, err)
+		assert.Equal(t, &MentionResponse{Tokens: 10}, res)
+		assert.Nil(t, posted.Body)
+	})
+
+	t.Run("should write the reply instead of posting it in a dry run", func(t *testing.T) {
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "Yes, the input is validated.", Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		writer := &dryRunWriterMock{}
+		var posted github.IssueComment
+
+		config := &Config{AppName: "nit", DryRunWriter: writer, RepoConfig: RepoConfig{DryRun: DryRunOn}}
+		res, err := RespondToMention(context.Background(), event, config, mockAI, setupGithubMock(&posted))
+
+		assert.Nil(t, err)
+		assert.Nil(t, posted.Body)
+		want := &DryRun{Owner: "user", Repo: "repo", Number: 123, Tokens: 10, Reply: "Yes, the input is validated."}
+		assert.Equal(t, []*DryRun{want}, writer.runs)
+		assert.Equal(t, &MentionResponse{Tokens: 10, DryRun: want}, res)
+	})
+}
@@ -0,0 +1,140 @@
+package nit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The Prometheus metrics of the app. They are registered with the default registry and
+// served by promhttp.Handler().
+var (
+	webhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nit_webhook_events_total",
+		Help: "Webhook deliveries received, by event, action and outcome.",
+	}, []string{"event", "action", "outcome"})
+
+	skippedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nit_skipped_total",
+		Help: "Events that were not handled, by handler and reason.",
+	}, []string{"handler", "reason"})
+
+	completionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nit_completion_duration_seconds",
+		Help:    "Time taken to create completions, by provider, model and tier.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 20, 40, 80, 160},
+	}, []string{"provider", "model", "tier"})
+
+	completionTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nit_completion_tokens_total",
+		Help: "Tokens used by completions, by provider, model and tier.",
+	}, []string{"provider", "model", "tier"})
+
+	githubRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nit_github_requests_total",
+		Help: "Requests made to the GitHub API, by method and status code.",
+	}, []string{"method", "status"})
+
+	githubRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nit_github_rate_limit_remaining",
+		Help: "Requests remaining in the current GitHub rate limit window, as of the last response.",
+	})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nit_queue_depth",
+		Help: "Webhook deliveries and reviews that are being handled.",
+	})
+
+	reviewComments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nit_review_comments_total",
+		Help: "Generated review comments, by outcome (posted, repositioned or dropped when fixing the review payload).",
+	}, []string{"outcome"})
+)
+
+// The outcomes of review comments.
+const (
+	commentPosted       = "posted"
+	commentRepositioned = "repositioned"
+	commentDropped      = "dropped"
+)
+
+// Count a webhook delivery. The outcome is how the delivery was handled (such as
+// "handled", "error", "skipped", "duplicate" or "invalid").
+func RecordWebhook(event, action, outcome string) {
+	webhookEvents.WithLabelValues(event, action, outcome).Inc()
+}
+
+// Count an event that a handler (such as "review" or "reply") decided not to handle, with
+// the reason returned by its Should function. Anything after a colon in the reason (such
+// as an error message) is left out to keep the number of reasons small.
+func RecordSkip(handler, reason string) {
+	reason, _, _ = strings.Cut(reason, ":")
+	skippedEvents.WithLabelValues(handler, reason).Inc()
+}
+
+// Count a webhook delivery or review as being handled. The returned function must be
+// called when it is done.
+func TrackQueue() func() {
+	queueDepth.Inc()
+	return queueDepth.Dec
+}
+
+// Record the latency and tokens of a completion.
+func recordCompletion(provider AIProvider, tier string, resp *CompletionResponse, elapsed time.Duration) {
+	model := ""
+	if resp != nil {
+		model = resp.Model
+	}
+
+	completionDuration.WithLabelValues(providerName(provider), model, tier).Observe(elapsed.Seconds())
+	if resp != nil {
+		completionTokens.WithLabelValues(providerName(provider), model, tier).Add(float64(resp.Tokens))
+	}
+}
+
+// The name of an AI provider for metric labels.
+func providerName(provider AIProvider) string {
+	switch provider.(type) {
+	case *openAIProvider:
+		return "openai"
+	case *anthropicProvider:
+		return "anthropic"
+	default:
+		return fmt.Sprintf("%T", provider)
+	}
+}
+
+// Wraps an http.RoundTripper to count the requests made to the GitHub API and record the
+// rate limit remaining from the response headers. The default transport is used when base
+// is nil.
+//
+//	gh := github.NewClient(&http.Client{Transport: nit.NewMetricsTransport(nil)})
+func NewMetricsTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &metricsTransport{base: base}
+}
+
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		githubRequests.WithLabelValues(req.Method, "error").Inc()
+		return resp, err
+	}
+
+	githubRequests.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		githubRateLimitRemaining.Set(float64(remaining))
+	}
+	return resp, nil
+}
@@ -0,0 +1,73 @@
+package nit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("should count GitHub requests and record the rate limit remaining", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "4321")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		before := testutil.ToFloat64(githubRequests.WithLabelValues("GET", "404"))
+		client := &http.Client{Transport: NewMetricsTransport(nil)}
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, before+1, testutil.ToFloat64(githubRequests.WithLabelValues("GET", "404")))
+		assert.Equal(t, float64(4321), testutil.ToFloat64(githubRateLimitRemaining))
+	})
+
+	t.Run("should record the tokens of completions by provider, model and tier", func(t *testing.T) {
+		provider := &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "ok", Tokens: 7, Model: "test-model"}, nil
+			},
+		}
+		ai := NewAI(provider, provider)
+
+		tokens := completionTokens.WithLabelValues("*nit.AIProviderMock", "test-model", "cheap")
+		before := testutil.ToFloat64(tokens)
+		_, err := ai.NewCompletion().Cheap().Create(context.Background(), "prompt")
+		assert.NoError(t, err)
+
+		assert.Equal(t, before+7, testutil.ToFloat64(tokens))
+	})
+
+	t.Run("should count comments dropped and repositioned when fixing the payload", func(t *testing.T) {
+		diff := "diff --git a/file.go b/file.go\n@@ -1,2 +1,2 @@\n line\n+line\n"
+		body := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("file.go"), Position: github.Int(10)},
+				{Path: github.String("missing.go"), Position: github.Int(1)},
+			},
+		}
+
+		dropped := testutil.ToFloat64(reviewComments.WithLabelValues(commentDropped))
+		repositioned := testutil.ToFloat64(reviewComments.WithLabelValues(commentRepositioned))
+		(&AI{}).fixProblemsWithPayload(context.Background(), diff, body)
+
+		assert.Len(t, body.Comments, 1)
+		assert.Equal(t, dropped+1, testutil.ToFloat64(reviewComments.WithLabelValues(commentDropped)))
+		assert.Equal(t, repositioned+1, testutil.ToFloat64(reviewComments.WithLabelValues(commentRepositioned)))
+	})
+
+	t.Run("should leave anything after a colon out of skip reasons", func(t *testing.T) {
+		skipped := skippedEvents.WithLabelValues("reply", "could not retrieve original comment")
+		before := testutil.ToFloat64(skipped)
+		RecordSkip("reply", "could not retrieve original comment: 404 Not Found")
+
+		assert.Equal(t, before+1, testutil.ToFloat64(skipped))
+	})
+}
@@ -50,6 +50,7 @@ func (o *openAIProvider) CreateCompletetion(req *CompletionRequest) (*Completion
 	resp := &CompletionResponse{
 		Completion: completion.Choices[0].Message.Content,
 		Tokens:     completion.Usage.TotalTokens,
+		Model:      model,
 	}
 
 	return resp, nil
@@ -0,0 +1,100 @@
+package nit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	// The number of pages of a list retrieved when the cap isn't configured.
+	defaultMaxPages = 10
+	// The number of items requested per page, the most GitHub allows.
+	listPageSize = 100
+	// The longest to wait for a rate limit to reset before retrying a request. Requests
+	// that would need to wait longer fail with the rate limit error.
+	maxRateLimitWait = time.Minute
+)
+
+// Waits for a rate limit to reset, replaced in tests.
+var rateLimitSleep = time.Sleep
+
+// Get every page of a GitHub list endpoint by following the "next" links of the responses,
+// up to maxPages pages (defaultMaxPages when it isn't positive). The list function is given
+// the options of the page to retrieve. The items retrieved before an error are returned
+// along with it.
+func paginate[T any](maxPages int, list func(opts *github.ListOptions) ([]T, *github.Response, error)) ([]T, error) {
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	all := []T{}
+	opts := &github.ListOptions{PerPage: listPageSize}
+	for page := 0; page < maxPages; page++ {
+		var (
+			items []T
+			resp  *github.Response
+		)
+		err := retryRateLimit(func() (err error) {
+			items, resp, err = list(opts)
+			return err
+		})
+		if err != nil {
+			return all, err
+		}
+		all = append(all, items...)
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts = &github.ListOptions{PerPage: listPageSize, Page: resp.NextPage}
+	}
+	return all, nil
+}
+
+// Make a GitHub API call, waiting and retrying it when it is rate limited. The call is only
+// retried once, a call that is rate limited again fails with the rate limit error. Secondary
+// rate limits say how long to wait with the Retry-After or X-RateLimit-Reset headers and
+// primary rate limits with the reset time of the rate. Nothing is retried when the wait
+// would be longer than maxRateLimitWait. Rate limited requests aren't carried out by GitHub
+// so writes are retried too.
+func retryRateLimit(call func() error) error {
+	err := call()
+	wait, ok := rateLimitWait(err)
+	if !ok {
+		return err
+	}
+	rateLimitSleep(wait)
+	return call()
+}
+
+// Get how long to wait before retrying a request that failed with err. Returns false when
+// the request wasn't rate limited or the wait is too long.
+func rateLimitWait(err error) (time.Duration, bool) {
+	var (
+		secondary *github.AbuseRateLimitError
+		primary   *github.RateLimitError
+		wait      time.Duration
+	)
+	switch {
+	case errors.As(err, &secondary):
+		// GitHub asks to wait at least a minute when it doesn't say how long
+		wait = maxRateLimitWait
+		if secondary.RetryAfter != nil {
+			wait = secondary.GetRetryAfter()
+		}
+	case errors.As(err, &primary):
+		wait = time.Until(primary.Rate.Reset.Time)
+	default:
+		return 0, false
+	}
+
+	if wait > maxRateLimitWait {
+		return 0, false
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
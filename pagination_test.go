@@ -0,0 +1,202 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	pages := []interface{}{
+		[]*github.PullRequestComment{{ID: github.Int64(1)}, {ID: github.Int64(2)}},
+		[]*github.PullRequestComment{{ID: github.Int64(3)}},
+		[]*github.PullRequestComment{{ID: github.Int64(4)}},
+	}
+	ids := func(comments []*github.PullRequestComment) []int64 {
+		result := []int64{}
+		for _, comment := range comments {
+			result = append(result, comment.GetID())
+		}
+		return result
+	}
+
+	t.Run("should follow the next links to get every page", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchPages(ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber, pages...),
+		))
+
+		comments := getExistingReviewComments(context.Background(), "owner", "repo", 1, 0, mockGithub)
+
+		assert.Equal(t, []int64{1, 2, 3, 4}, ids(comments))
+	})
+
+	t.Run("should stop at the page cap", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchPages(ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber, pages...),
+		))
+
+		comments := getExistingReviewComments(context.Background(), "owner", "repo", 1, 2, mockGithub)
+
+		assert.Equal(t, []int64{1, 2, 3}, ids(comments))
+	})
+
+	t.Run("should get every page of commits and reviews", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchPages(
+				ghMock.GetReposPullsCommitsByOwnerByRepoByPullNumber,
+				[]*github.RepositoryCommit{{Commit: &github.Commit{Message: github.String("first")}}},
+				[]*github.RepositoryCommit{{Commit: &github.Commit{Message: github.String("second")}}},
+			),
+			ghMock.WithRequestMatchPages(
+				ghMock.GetReposPullsReviewsByOwnerByRepoByPullNumber,
+				[]*github.PullRequestReview{{ID: github.Int64(1), State: github.String("APPROVED"), User: &github.User{Login: github.String("nit")}}},
+				[]*github.PullRequestReview{{ID: github.Int64(2), State: github.String("CHANGES_REQUESTED"), User: &github.User{Login: github.String("nit")}}},
+			),
+		))
+
+		assert.Equal(t, []string{"first", "second"}, getCommitMessages(context.Background(), "owner", "repo", 1, 0, mockGithub))
+
+		review, err := getBlockingReview(context.Background(), "owner", "repo", 1, "nit", 0, mockGithub)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), review.GetID())
+	})
+
+	t.Run("should return the items retrieved before an error", func(t *testing.T) {
+		requests := 0
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requests++
+					if requests > 1 {
+						ghMock.WriteError(w, http.StatusInternalServerError, "github went belly up or something")
+						return
+					}
+					w.Header().Add("Link", fmt.Sprintf(`<%s?page=2>; rel="next"`, r.URL.Path))
+					w.Write(ghMock.MustMarshal(pages[0]))
+				}),
+			),
+		))
+
+		comments, err := paginate(0, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+			return mockGithub.PullRequests.ListComments(context.Background(), "owner", "repo", 1, &github.PullRequestListCommentsOptions{ListOptions: *opts})
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, []int64{1, 2}, ids(comments))
+	})
+}
+
+func TestGetReviewThreadsPagination(t *testing.T) {
+	cursors := []interface{}{}
+	mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatchHandler(
+			ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req graphQLRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				cursors = append(cursors, req.Variables["cursor"])
+				if req.Variables["cursor"] == nil {
+					w.Write([]byte(`{"data": {"repository": {"pullRequest": {"reviewThreads": {"pageInfo": {"hasNextPage": true, "endCursor": "c1"}, "nodes": [{"id": "T1"}]}}}}}`))
+					return
+				}
+				w.Write([]byte(`{"data": {"repository": {"pullRequest": {"reviewThreads": {"pageInfo": {"hasNextPage": false}, "nodes": [{"id": "T2"}]}}}}}`))
+			}),
+		),
+	))
+
+	threads, err := getReviewThreads(context.Background(), "owner", "repo", 1, 0, mockGithub)
+
+	assert.Nil(t, err)
+	assert.Len(t, threads, 2)
+	assert.Equal(t, "T2", threads[1].ID)
+	assert.Equal(t, []interface{}{nil, "c1"}, cursors)
+}
+
+func TestRetryRateLimit(t *testing.T) {
+	waits := []time.Duration{}
+	rateLimitSleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { rateLimitSleep = time.Sleep }()
+
+	// respond with a secondary rate limit the first time
+	setupGithub := func(retryAfter string, requests *int) *github.Client {
+		return github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					*requests++
+					if *requests == 1 {
+						w.Header().Set("Retry-After", retryAfter)
+						w.WriteHeader(http.StatusForbidden)
+						w.Write([]byte(`{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`))
+						return
+					}
+					w.Write(ghMock.MustMarshal([]*github.PullRequestComment{{ID: github.Int64(1)}}))
+				}),
+			),
+		))
+	}
+
+	t.Run("should wait and retry requests that hit a secondary rate limit", func(t *testing.T) {
+		waits = waits[:0]
+		requests := 0
+		mockGithub := setupGithub("0", &requests)
+
+		comments, err := paginate(0, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+			return mockGithub.PullRequests.ListComments(context.Background(), "owner", "repo", 1, &github.PullRequestListCommentsOptions{ListOptions: *opts})
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, comments, 1)
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, []time.Duration{0}, waits)
+	})
+
+	t.Run("should not wait for rate limits that reset too late", func(t *testing.T) {
+		waits = waits[:0]
+		requests := 0
+		mockGithub := setupGithub("3600", &requests)
+
+		_, err := paginate(0, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+			return mockGithub.PullRequests.ListComments(context.Background(), "owner", "repo", 1, &github.PullRequestListCommentsOptions{ListOptions: *opts})
+		})
+
+		var rateLimitErr *github.AbuseRateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr)
+		assert.Equal(t, 1, requests)
+		assert.Empty(t, waits)
+	})
+
+	t.Run("should wait for the primary rate limit to reset", func(t *testing.T) {
+		reset := time.Now().Add(30 * time.Second)
+		err := &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: reset}}}
+
+		wait, ok := rateLimitWait(err)
+
+		assert.True(t, ok)
+		assert.InDelta(t, 30*time.Second, wait, float64(time.Second))
+	})
+
+	t.Run("should only retry once", func(t *testing.T) {
+		waits = waits[:0]
+		calls := 0
+		retryAfter := time.Duration(0)
+
+		err := retryRateLimit(func() error {
+			calls++
+			return &github.AbuseRateLimitError{RetryAfter: &retryAfter}
+		})
+
+		var rateLimitErr *github.AbuseRateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr)
+		assert.Equal(t, 2, calls)
+		assert.Len(t, waits, 1)
+	})
+}
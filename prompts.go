@@ -1,29 +1,100 @@
 package nit
 
+// The version of the prompts, recorded with each review so that changes to the prompts can
+// be compared. Bump it when the prompts change.
+const PromptVersion = "1"
+
 const reviewCommentsPrompt = `%s
 
-The changes from the git diff:
+%sThe changes from the git diff:
 %s
 
 Review this pull request. Leave comments for specific positions in the diff when you have something constructive to say. Be critical as you have high standards. Don't point out the obvious. A good PR review is thoughtful and contructive with specific and actionable feedback on the changes.
+If the pull request links to issues, check whether the changes actually do what the issues ask (including any acceptance criteria) and say so in the summary.
 
 Format your response like this:
 Summary: Provide a concise summary of your comments on the pull request.
 Event: APPROVE or COMMENT
 1. File path: path/to/file.py
 Position: 4
+Severity: minor
+Category: style
 Comment: "Contructive comment..."
 2. File path: path/to/anouther/file.py
+Start position: 14
 Position: 16
+Severity: major
+Category: bug
 Comment: "Anouther contructive comment ..."
+Original:
+"the exact current code on positions 14 to 16"
+Suggestion:
+"the code that should replace it"
 ... for as many comments as needed
 
 The "Position" value is the number of lines down from the first "@@" hunk header in the file you want to add a comment. The line just below the "@@" line is position 1, the next line is position 2, and so on. The position in the diff continues to increase through lines of whitespace and additional hunks until the beginning of a new file which starts with "diff --git".
 The "File path" for a comment is the path to the file as described on the line "diff --git a/path/to/file.py b/path/to/file.py". It should not start with a slash or the "a/" and "b/" prefixes that are used in the diff.
+The "Severity" value is how important the comment is: "blocker" (must be fixed before merging, like bugs that break things or security holes), "major" (should be fixed, like incorrect edge cases or missing error handling), "minor" (worth improving, like readability or small inefficiencies) or "nit" (small matters of taste).
+The "Category" value is what the comment is about: "bug", "security", "style", "perf" or "test".
+The "Start position" is optional and only needed when a comment is about a range of lines, it is the position of the first line in the range and "Position" is the last.
+The "Original" and "Suggestion" values are optional. Only include them when you can suggest a concrete fix for the commented line (or range of lines). "Original" must be exactly the current code on those lines in the new version of the file (without the diff position numbers and the "+" or " " prefixes) and "Suggestion" is the code that replaces all of those lines. Suggestions can't be made on removed lines.
 The "Event" value should be either "APPROVE" or "COMMENT". Use "APPROVE" when the changes are fine and can be me merged as is, even if you provide additional comments or suggestions. Use "COMMENT" when the changes are not ready to be merged yet and your feedback should be acted upon.
 
 Begin!`
 
+const codeContextPrompt = `Source code from the repository to help you understand the changes. Only leave comments on the changes in the diff.
+
+%s
+`
+
+const fileContextPrompt = `The contents of the files changed in this pull request at the head commit. Lines are numbered as they are in the new version of the file and the lines added or modified by the pull request are marked with ">":
+%s`
+
+const symbolContextPrompt = `Definitions and call sites elsewhere in the repository (at the base commit) of the symbols used in the changes:
+%s`
+
+const conformancePrompt = `%s
+
+The changes from the git diff:
+%s
+
+Compare what the pull request title and description say the changes do with what the diff actually does. List the mismatches under these headings, leaving out headings with nothing under them:
+- Described but not implemented: behaviour the description claims that the diff doesn't implement.
+- Implemented but not described: significant changes in the diff that the description doesn't mention.
+- Missing tests: behaviour stated in the description that the diff doesn't add or update tests for.
+
+Be concise and specific, one short bullet point per mismatch. Don't comment on code quality. If the changes match the description, just return "noissues".`
+
+const conformanceSection = `%s
+
+### Requirements conformance
+%s`
+
+const describePrompt = `%s
+
+The changes from the git diff:
+%s
+
+The files changed:
+%s
+
+Write a description for this pull request in markdown for the reviewers. Use these sections:
+## Summary
+A few sentences on what the pull request does and why.
+## Changes
+A bullet point for each changed file with the path in backticks and a short description of what changed in it.
+## Risk areas
+The parts of the change most likely to cause problems and what reviewers should look at closely.
+## Testing notes
+How the changes were tested based on the diff, and what should still be tested.
+
+Be concise and only describe what is in the diff. Respond with the description only.`
+
+const otherNotesSection = `%s
+
+### Other notes
+%s`
+
 const reviewPostBodyPrompt = `Generate the request body to POST the pull request review notes to github. Format your response as a JSON object.
 
 PR details:
@@ -43,6 +114,18 @@ The review action you want to perform. The review actions include: APPROVE or CO
     The relative path to the file that necessitates a comment. This should not start with a slash.
     - position: integer
     The position in the diff where you want to add a review comment.
+    - severity: string, Required
+    The severity of the comment from the notes: blocker, major, minor or nit.
+    - category: string, Required
+    The category of the comment from the notes: bug, security, style, perf or test.
+    - start_position: integer
+    The first position of a comment on a range of lines. Only include it if the notes have a start position for the comment.
+    - suggestion: object
+    Only include it if the notes have an original and suggestion for the comment.
+        - original: string, Required
+        The original code exactly as it is in the notes.
+        - replacement: string, Required
+        The suggested code exactly as it is in the notes.
 
 Request body JSON:`
 
@@ -50,8 +133,71 @@ const commentReplyPrompt = `Write a response to this pull request comment: %s
 
 Comment hunk:
 %s
+%s
+All comments in this thread. You are acting in this exchange as %s:
+%s
+
+If there is nothing to say, just return "noreply". Otherwise, be concise`
+
+const commentCodePrompt = `
+The head version of the file, the lines the thread is on are marked with ">":
+%s
+If the author asks how to fix something or a change to the code would clearly help, end your response with the marked lines exactly as they are (without the line numbers) followed by the code to replace them with, like this:
+` + "```original\nthe marked lines\n```\n```suggestion\nthe replacement\n```" + `
+`
+
+const critiquePrompt = `You are checking a comment that is about to be left on a pull request review. Only comments that help the author should be posted.
+
+The comment is on the file %s, in this diff hunk:
+%s
+
+Comment:
+%s
+
+Decide if the comment is:
+1. Correct: what it says about the code is true for the code in the hunk.
+2. Actionable: the author can do something about it.
+3. Non-obvious: it tells the author something they wouldn't already know from reading the change.
+
+Respond in JSON with "keep" (true only if the comment is all three) and "reason" (one short sentence explaining the decision). For example:
+{"keep": false, "reason": "The error is already handled on the next line."}`
+
+const resolveThreadPrompt = `You left a comment on a pull request and the author has since pushed new changes. Decide if the concern raised in the thread has been addressed.
 
 All comments in this thread. You are acting in this exchange as %s:
 %s
 
+The diff hunk the thread was started on:
+%s
+
+The current diff of the file in the pull request:
+%s
+
+Respond in JSON with "resolved" (true if the concern is addressed or no longer applies) and "missing" (when it isn't resolved, a short reply to the author explaining what is still missing). For example:
+{"resolved": false, "missing": "The error from Close is still ignored, it should be returned."}`
+
+const blockingIssuesPrompt = `You requested changes on a pull request because of blocking issues and the author has since pushed new changes. Decide which of the blocking issues are still present.
+
+The blocking issues, each with the file and the diff hunk it was raised on:
+%s
+
+The current diff of the pull request:
+%s
+
+Respond in JSON with "remaining", a short description of each blocking issue that the current changes don't fix. It is empty when all of them are fixed or no longer apply. For example:
+{"remaining": ["The error from Close is still ignored."]}`
+
+const mentionReplyPrompt = `You were mentioned in a comment on a pull request. Write a response to it.
+
+%s
+
+The diff of the pull request:
+%s
+
+The conversation on the pull request so far. You are acting in this exchange as %s:
+%s
+
+The comment that mentions you, by %s:
+%s
+
 If there is nothing to say, just return "noreply". Otherwise, be concise`
@@ -0,0 +1,129 @@
+package nit
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	// The maximum number of linked issues added to the review prompt.
+	maxLinkedIssues = 5
+	// Issue bodies longer than this are truncated in the review prompt.
+	maxIssueBodyLength = 4000
+)
+
+var (
+	// Matches the keywords GitHub uses to link issues to pull requests, like "fixes #123"
+	// or "closes owner/repo#123".
+	// see https://docs.github.com/en/issues/tracking-your-work-with-issues/linking-a-pull-request-to-an-issue
+	linkedIssueRegex = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\s*:?\s+(?:([\w.-]+)/([\w.-]+))?#(\d+)\b`)
+	// Matches a markdown heading or bold line for an "Acceptance criteria" section.
+	acceptanceCriteriaRegex = regexp.MustCompile(`(?im)^\s*(?:#+\s*|\*\*)acceptance criteria:?(?:\*\*)?:?\s*$`)
+	markdownHeadingRegex    = regexp.MustCompile(`(?m)^\s*#+\s+\S`)
+)
+
+// A reference to an issue in a pull request description.
+type issueReference struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+// Find the issues linked from a pull request description. References without an
+// owner and repository are to issues in the same repository. References to issues in
+// other repositories are left out, they could be private and end up quoted in a review on
+// a public repository.
+func parseLinkedIssues(owner, repo, description string) []issueReference {
+	refs := []issueReference{}
+	seen := map[issueReference]bool{}
+	for _, match := range linkedIssueRegex.FindAllStringSubmatch(description, -1) {
+		number, err := strconv.Atoi(match[3])
+		if err != nil {
+			continue
+		}
+
+		if match[1] != "" && (!strings.EqualFold(match[1], owner) || !strings.EqualFold(match[2], repo)) {
+			continue
+		}
+
+		ref := issueReference{Owner: owner, Repo: repo, Number: number}
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// Get the issues linked from the pull request description. This is best effort, issues
+// that can't be retrieved (deleted, private, etc) are left out.
+func getLinkedIssues(ctx context.Context, owner, repo, description string, gh *github.Client) []*github.Issue {
+	issues := []*github.Issue{}
+	for _, ref := range parseLinkedIssues(owner, repo, description) {
+		if len(issues) == maxLinkedIssues {
+			break
+		}
+
+		var issue *github.Issue
+		err := retryRateLimit(func() (err error) {
+			issue, _, err = gh.Issues.Get(ctx, ref.Owner, ref.Repo, ref.Number)
+			return err
+		})
+		if err != nil || issue.IsPullRequest() {
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// Get the messages of the commits in a pull request. This is best effort, nothing is
+// returned if the commits can't be retrieved.
+func getCommitMessages(ctx context.Context, owner, repo string, number, maxPages int, gh *github.Client) []string {
+	commits, err := paginate(maxPages, func(opts *github.ListOptions) ([]*github.RepositoryCommit, *github.Response, error) {
+		return gh.PullRequests.ListCommits(ctx, owner, repo, number, opts)
+	})
+	if err != nil {
+		return nil
+	}
+
+	messages := []string{}
+	for _, commit := range commits {
+		if message := commit.GetCommit().GetMessage(); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Get the contents of the "Acceptance criteria" section of an issue body, up to the next
+// markdown heading. Returns an empty string when there is no such section.
+func extractAcceptanceCriteria(body string) string {
+	loc := acceptanceCriteriaRegex.FindStringIndex(body)
+	if loc == nil {
+		return ""
+	}
+
+	section := body[loc[1]:]
+	if next := markdownHeadingRegex.FindStringIndex(section); next != nil {
+		section = section[:next[0]]
+	}
+	return strings.TrimSpace(section)
+}
+
+// Truncate a string to at most n bytes, without splitting a multi-byte character
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
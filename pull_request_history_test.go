@@ -0,0 +1,126 @@
+package nit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinkedIssues(t *testing.T) {
+	t.Run("should find issues linked with closing keywords", func(t *testing.T) {
+		description := "This fixes #12 and Closes: owner/repo#34.\nresolved #12 again, see #56 for context"
+
+		got := parseLinkedIssues("owner", "repo", description)
+
+		assert.Equal(t, []issueReference{
+			{Owner: "owner", Repo: "repo", Number: 12},
+			{Owner: "owner", Repo: "repo", Number: 34},
+		}, got)
+	})
+
+	t.Run("should leave out issues in other repositories", func(t *testing.T) {
+		description := "fixes other/repo#1, fixes owner/private#2 and fixes Owner/Repo#3"
+
+		got := parseLinkedIssues("owner", "repo", description)
+
+		assert.Equal(t, []issueReference{{Owner: "owner", Repo: "repo", Number: 3}}, got)
+	})
+
+	t.Run("should find nothing when no issues are linked", func(t *testing.T) {
+		got := parseLinkedIssues("owner", "repo", "just a description with #7")
+
+		assert.Empty(t, got)
+	})
+}
+
+func TestGetLinkedIssues(t *testing.T) {
+	t.Run("should skip issues that are pull requests or can't be retrieved", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposIssuesByOwnerByRepoByIssueNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/repos/owner/repo/issues/1":
+						w.Write(ghMock.MustMarshal(github.Issue{Number: github.Int(1), Title: github.String("issue")}))
+					case "/repos/owner/repo/issues/2":
+						w.Write(ghMock.MustMarshal(github.Issue{
+							Number:           github.Int(2),
+							PullRequestLinks: &github.PullRequestLinks{URL: github.String("url")},
+						}))
+					default:
+						ghMock.WriteError(w, http.StatusNotFound, "not found")
+					}
+				}),
+			),
+		))
+
+		got := getLinkedIssues(context.Background(), "owner", "repo", "fixes #1, fixes #2, fixes #3", mockGithub)
+
+		assert.Len(t, got, 1)
+		assert.Equal(t, 1, got[0].GetNumber())
+	})
+}
+
+func TestFormatPullRequestDetails(t *testing.T) {
+	t.Run("should format only the basic details when there is no history", func(t *testing.T) {
+		got := formatPullRequestDetails(&PullRequestDetails{Number: 1, Title: "title", Description: "description"})
+
+		assert.Equal(t, "Pull Request #1\nTitle: title\ndescription", got)
+	})
+
+	t.Run("should include commit messages and linked issues", func(t *testing.T) {
+		details := &PullRequestDetails{
+			Number:      1,
+			Title:       "title",
+			Description: "fixes #2",
+			Commits:     []string{"First commit", "Second commit\n\nWith a body"},
+			Issues: []*github.Issue{
+				{
+					Number: github.Int(2),
+					Title:  github.String("Broken thing"),
+					Body:   github.String("The thing is broken.\n\n## Acceptance criteria\n- thing works\n\n## Notes\nnone"),
+				},
+			},
+		}
+
+		want := "Pull Request #1\nTitle: title\nfixes #2\n\nCommits:\n- First commit\n- Second commit\n  \n  With a body\n\n\nLinked issue #2: Broken thing\nThe thing is broken.\n\n## Acceptance criteria\n- thing works\n\n## Notes\nnone"
+		got := formatPullRequestDetails(details)
+
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("should keep the acceptance criteria of truncated issues", func(t *testing.T) {
+		body := ""
+		for len(body) < maxIssueBodyLength {
+			body += "Lots of words. "
+		}
+		body += "\n\n**Acceptance criteria**\n- thing works"
+
+		got := formatPullRequestDetails(&PullRequestDetails{
+			Number: 1,
+			Title:  "title",
+			Issues: []*github.Issue{{Number: github.Int(2), Body: github.String(body)}},
+		})
+
+		assert.Contains(t, got, "...\nAcceptance criteria:\n- thing works")
+	})
+}
+
+func TestTruncateString(t *testing.T) {
+	t.Run("should leave short strings as they are", func(t *testing.T) {
+		assert.Equal(t, "short", truncateString("short", 5))
+	})
+
+	t.Run("should not split multi-byte characters", func(t *testing.T) {
+		// "é" is two bytes, cutting at 2 bytes would split it
+		got := truncateString("aéb", 2)
+
+		assert.Equal(t, "a...", got)
+		assert.True(t, utf8.ValidString(got))
+	})
+}
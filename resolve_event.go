@@ -0,0 +1,199 @@
+package nit
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+type ResolveResponse struct {
+	Tokens int
+	// The number of threads that were resolved
+	Resolved int
+	// The number of threads that were replied to with what is still missing
+	Replied int
+}
+
+func ShouldResolveThreads(e *github.PullRequestEvent, c *Config) (bool, string) {
+	var (
+		author      = e.GetPullRequest().GetUser().GetLogin()
+		action      = e.GetAction()
+		description = e.GetPullRequest().GetBody()
+	)
+
+	switch {
+	// ignore pull requests oppened by bots
+	// the [bot] postfix is added by github to app accounts
+	case strings.Contains(author, "[bot]"):
+		return false, "pull request made by a bot"
+	// only pushes to the pull request can fix the issues raised in threads
+	case action != "synchronize":
+		return false, "pull request was not \"synchronize\""
+	case strings.Contains(description, "ai-review:ignore"):
+		return false, "pull request marked as ignore"
+	default:
+		return true, ""
+	}
+}
+
+// Re-check the unresolved review threads started by the app on the files changed by a push
+// to a pull request. Threads whose concern has been addressed are resolved, the rest are
+// replied to with what is still missing unless the app already has the last word.
+func ResolveThreads(ctx context.Context, event *github.PullRequestEvent, config *Config, ai *AI, gh *github.Client) (*ResolveResponse, error) {
+	var (
+		owner      = event.GetRepo().GetOwner().GetLogin()
+		repository = event.GetRepo().GetName()
+		number     = event.GetPullRequest().GetNumber()
+		before     = event.GetBefore()
+		after      = event.GetAfter()
+	)
+
+	threads, err := getReviewThreads(ctx, owner, repository, number, config.MaxPages, gh)
+	if err != nil {
+		return nil, err
+	}
+
+	open := []*reviewThread{}
+	for _, thread := range threads {
+		first := thread.first()
+		if thread.IsResolved || first == nil || config.AppName == "" || !strings.Contains(first.Author.Login, config.AppName) {
+			continue
+		}
+		open = append(open, thread)
+	}
+	if len(open) == 0 {
+		return &ResolveResponse{}, nil
+	}
+
+	var diff string
+	err = retryRateLimit(func() (err error) {
+		diff, _, err = gh.PullRequests.GetRaw(
+			ctx,
+			owner,
+			repository,
+			number,
+			github.RawOptions{Type: github.Diff},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	hunks := splitDiffHunks(diff)
+	pushed := getPushedFiles(ctx, owner, repository, before, after, gh)
+	dryRun := isDryRun(config, owner, repository, event.GetPullRequest().GetBody())
+
+	res := &ResolveResponse{}
+	for _, thread := range open {
+		// Only the files changed by the push can have fixed anything
+		if pushed != nil && !pushed[thread.Path] {
+			continue
+		}
+
+		resolution, resp, err := ai.generateThreadResolution(
+			ctx,
+			thread.pullRequestComments(),
+			thread.first().DiffHunk,
+			strings.Join(hunks[thread.Path], "\n"),
+			config.AppName,
+		)
+		if resp != nil {
+			res.Tokens += resp.Tokens
+		}
+		if err != nil {
+			return res, err
+		}
+
+		if resolution.Resolved {
+			if dryRun {
+				err = writeDryRun(config, ai, stageResolve, &DryRun{
+					Owner:  owner,
+					Repo:   repository,
+					Number: number,
+					Tokens: resp.Tokens,
+					Action: dryRunResolve,
+					Target: "thread " + thread.ID,
+				})
+			} else {
+				err = resolveReviewThread(ctx, thread.ID, gh)
+			}
+			if err != nil {
+				return res, err
+			}
+			res.Resolved++
+			continue
+		}
+		if strings.TrimSpace(resolution.Missing) == "" {
+			continue
+		}
+		// Already said what is missing and the author hasn't replied, don't repeat it on
+		// every push
+		if len(thread.Comments.Nodes) > 1 && strings.Contains(thread.last().Author.Login, config.AppName) {
+			continue
+		}
+
+		if dryRun {
+			err = writeDryRun(config, ai, stageResolve, &DryRun{
+				Owner:     owner,
+				Repo:      repository,
+				Number:    number,
+				Tokens:    resp.Tokens,
+				Action:    dryRunResolve,
+				Target:    "thread " + thread.ID,
+				Reply:     resolution.Missing,
+				InReplyTo: thread.first().DatabaseID,
+			})
+		} else {
+			err = retryRateLimit(func() (err error) {
+				_, _, err = gh.PullRequests.CreateCommentInReplyTo(
+					ctx,
+					owner,
+					repository,
+					number,
+					resolution.Missing,
+					thread.first().DatabaseID,
+				)
+				return err
+			})
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Replied++
+	}
+	ai.Logger().Info("checked review threads", "stage", stageResolve, "threads", len(open), "resolved", res.Resolved, "replied", res.Replied, "tokens", res.Tokens)
+
+	return res, nil
+}
+
+// Get the paths of the files changed between two commits. Returns nil when they can't be
+// compared (such as after a force push) so that every file is considered changed.
+func getPushedFiles(ctx context.Context, owner, repo, before, after string, gh *github.Client) map[string]bool {
+	if before == "" || after == "" {
+		return nil
+	}
+
+	var diff string
+	err := retryRateLimit(func() (err error) {
+		diff, _, err = gh.Repositories.CompareCommitsRaw(
+			ctx,
+			owner,
+			repo,
+			before,
+			after,
+			github.RawOptions{Type: github.Diff},
+		)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+
+	paths := map[string]bool{}
+	for _, file := range parseDiffFiles(diff) {
+		paths[file.Path] = true
+		paths[file.OldPath] = true
+	}
+	return paths
+}
@@ -0,0 +1,266 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldResolveThreads(t *testing.T) {
+	createEvent := func(action, author string) *github.PullRequestEvent {
+		return &github.PullRequestEvent{
+			Action: github.String(action),
+			PullRequest: &github.PullRequest{
+				Body: github.String("body"),
+				User: &github.User{Login: github.String(author)},
+			},
+		}
+	}
+
+	t.Run("should check pushes to pull requests", func(t *testing.T) {
+		ok, _ := ShouldResolveThreads(createEvent("synchronize", "user"), &Config{})
+		assert.True(t, ok)
+	})
+
+	t.Run("should ignore other actions", func(t *testing.T) {
+		ok, _ := ShouldResolveThreads(createEvent("opened", "user"), &Config{})
+		assert.False(t, ok)
+	})
+
+	t.Run("should ignore pull requests made by bots", func(t *testing.T) {
+		ok, _ := ShouldResolveThreads(createEvent("synchronize", "something[bot]"), &Config{})
+		assert.False(t, ok)
+	})
+}
+
+func TestResolveThreads(t *testing.T) {
+	const (
+		diff     = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3"
+		pushDiff = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\nline 1\n-line 2\n+new line 2"
+		threads  = `{"data": {"repository": {"pullRequest": {"reviewThreads": {"nodes": [
+			{"id": "T1", "isResolved": false, "path": "file.txt", "comments": {"nodes": [{"databaseId": 1, "body": "Handle the error.", "diffHunk": "@@ -1,2 +1,2 @@", "author": {"login": "nit"}}]}},
+			{"id": "T2", "isResolved": false, "path": "file.txt", "comments": {"nodes": [{"databaseId": 2, "body": "Add a test.", "diffHunk": "@@ -1,2 +1,2 @@", "author": {"login": "nit"}}]}},
+			{"id": "T3", "isResolved": true, "path": "file.txt", "comments": {"nodes": [{"databaseId": 3, "body": "Rename this.", "author": {"login": "nit"}}]}},
+			{"id": "T4", "isResolved": false, "path": "file.txt", "comments": {"nodes": [{"databaseId": 4, "body": "Why?", "author": {"login": "someone"}}]}},
+			{"id": "T5", "isResolved": false, "path": "other.txt", "comments": {"nodes": [{"databaseId": 5, "body": "Use a constant.", "author": {"login": "nit"}}]}}
+		]}}}}}`
+	)
+
+	event := &github.PullRequestEvent{
+		Action: github.String("synchronize"),
+		Before: github.String("before"),
+		After:  github.String("after"),
+		Repo: &github.Repository{
+			Name:  github.String("repo"),
+			Owner: &github.User{Login: github.String("user")},
+		},
+		PullRequest: &github.PullRequest{
+			Number: github.Int(123),
+			User:   &github.User{Login: github.String("user")},
+		},
+	}
+
+	t.Run("should resolve addressed threads and reply to the rest", func(t *testing.T) {
+		responses := []string{
+			`{"resolved": true}`,
+			`{"resolved": false, "missing": "There is still no test for the new line."}`,
+		}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				r := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: r, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		resolved := []string{}
+		var reply map[string]interface{}
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req graphQLRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					if strings.Contains(req.Query, "resolveReviewThread") {
+						resolved = append(resolved, req.Variables["threadId"].(string))
+						w.Write([]byte(`{"data": {"resolveReviewThread": {"thread": {"isResolved": true}}}}`))
+						return
+					}
+					w.Write([]byte(threads))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposCompareByOwnerByRepoByBasehead,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(pushDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&reply)
+					w.Write([]byte(`{"id": 6}`))
+				}),
+			),
+		))
+
+		res, err := ResolveThreads(context.Background(), event, &Config{AppName: "nit"}, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &ResolveResponse{Tokens: 20, Resolved: 1, Replied: 1}, res)
+		assert.Equal(t, []string{"T1"}, resolved)
+		assert.Equal(t, "There is still no test for the new line.", reply["body"])
+		assert.Equal(t, float64(2), reply["in_reply_to"])
+
+		// only the open threads started by the app on files changed by the push are checked
+		calls := mockProvider.calls.CreateCompletetion
+		assert.Len(t, calls, 2)
+		assert.Equal(t, fmt.Sprintf(resolveThreadPrompt, "nit", "nit: Handle the error.\n", "@@ -1,2 +1,2 @@", "@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3"), calls[0].Req.Prompt)
+		assert.Equal(t, formatJSON, calls[0].Req.Format)
+	})
+
+	t.Run("should write the resolutions and replies instead of making them in a dry run", func(t *testing.T) {
+		responses := []string{
+			`{"resolved": true}`,
+			`{"resolved": false, "missing": "There is still no test for the new line."}`,
+		}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				r := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: r, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		writer := &dryRunWriterMock{}
+
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req graphQLRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					if strings.Contains(req.Query, "resolveReviewThread") {
+						t.Error("the thread should not be resolved")
+					}
+					w.Write([]byte(threads))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposCompareByOwnerByRepoByBasehead,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(pushDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the reply should not be posted")
+				}),
+			),
+		))
+
+		config := &Config{AppName: "nit", DryRunWriter: writer, RepoConfig: RepoConfig{DryRun: DryRunOn}}
+		res, err := ResolveThreads(context.Background(), event, config, mockAI, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &ResolveResponse{Tokens: 20, Resolved: 1, Replied: 1}, res)
+		assert.Equal(t, []*DryRun{
+			{Owner: "user", Repo: "repo", Number: 123, Tokens: 10, Action: dryRunResolve, Target: "thread T1"},
+			{Owner: "user", Repo: "repo", Number: 123, Tokens: 10, Action: dryRunResolve, Target: "thread T2", Reply: "There is still no test for the new line.", InReplyTo: 2},
+		}, writer.runs)
+	})
+
+	t.Run("should not repeat what is missing on later pushes", func(t *testing.T) {
+		const replied = `{"data": {"repository": {"pullRequest": {"reviewThreads": {"nodes": [
+			{"id": "T2", "isResolved": false, "path": "file.txt", "comments": {"nodes": [
+				{"databaseId": 2, "body": "Add a test.", "diffHunk": "@@ -1,2 +1,2 @@", "author": {"login": "nit"}},
+				{"databaseId": 6, "body": "There is still no test for the new line.", "author": {"login": "nit"}}
+			]}}
+		]}}}}}`
+
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: `{"resolved": false, "missing": "There is still no test for the new line."}`, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		replies := 0
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(replied))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(diff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposCompareByOwnerByRepoByBasehead,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(pushDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsCommentsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					replies++
+					w.Write([]byte(`{"id": 7}`))
+				}),
+			),
+		))
+
+		// the thread is still checked on every push so it can be resolved
+		for i := 0; i < 2; i++ {
+			res, err := ResolveThreads(context.Background(), event, &Config{AppName: "nit"}, mockAI, mockGithub)
+
+			assert.Nil(t, err)
+			assert.Equal(t, &ResolveResponse{Tokens: 10}, res)
+		}
+		assert.Equal(t, 0, replies)
+		assert.Len(t, mockProvider.calls.CreateCompletetion, 2)
+	})
+
+	t.Run("should return GraphQL errors", func(t *testing.T) {
+		mockProvider := AIProviderMock{}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"errors": [{"message": "Could not resolve to a PullRequest"}]}`))
+				}),
+			),
+		))
+
+		_, err := ResolveThreads(context.Background(), event, &Config{AppName: "nit"}, mockAI, mockGithub)
+
+		assert.EqualError(t, err, "graphql: Could not resolve to a PullRequest")
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/google/go-github/v59/github"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const maxReviewAttempts = 3
@@ -12,6 +13,16 @@ const maxReviewAttempts = 3
 type ReviewResponse struct {
 	Tokens int
 	Id     int64
+	// The number of comments dropped by the self-critique pass
+	Dropped int
+	// The number of comments dropped as duplicates
+	Duplicates int
+	// The model that generated the review comments
+	Model string
+	// The IDs of the comments posted with the review
+	CommentIds []int64
+	// The review that would have been posted when it was a dry run
+	DryRun *DryRun
 }
 
 func ShouldReviewPullRequest(e *github.PullRequestEvent, c *Config) (bool, string) {
@@ -42,46 +53,177 @@ func ShouldReviewPullRequest(e *github.PullRequestEvent, c *Config) (bool, strin
 	}
 }
 
-func ReviewPullRequest(event *github.PullRequestEvent, ai *AI, gh *github.Client) (*ReviewResponse, error) {
+func ReviewPullRequest(ctx context.Context, event *github.PullRequestEvent, config *Config, ai *AI, gh *github.Client) (*ReviewResponse, error) {
+	var (
+		owner      = event.GetRepo().GetOwner().GetLogin()
+		repository = event.GetRepo().GetName()
+		number     = event.GetPullRequest().GetNumber()
+	)
+
+	// Group the spans of the review under one span. Errors are recorded on the child spans.
+	ctx, reviewSpan := startSpan(ctx, "review")
+	defer reviewSpan.End()
+
+	_, body, stats, err := generateReview(ctx, event, config, ai, gh)
+	if err != nil {
+		// Failed runs are recorded with what was spent before the error
+		if stats != nil {
+			return &ReviewResponse{Tokens: stats.Tokens, Model: stats.Model}, err
+		}
+		return nil, err
+	}
+
+	if isDryRun(config, owner, repository, event.GetPullRequest().GetBody()) {
+		run := &DryRun{
+			Owner:   owner,
+			Repo:    repository,
+			Number:  number,
+			HeadSHA: event.GetPullRequest().GetHead().GetSHA(),
+			Model:   stats.Model,
+			Tokens:  stats.Tokens,
+			Review:  body,
+		}
+		if err := writeDryRun(config, ai, stageReview, run); err != nil {
+			return &ReviewResponse{Tokens: stats.Tokens, Model: stats.Model}, err
+		}
+		return &ReviewResponse{
+			Tokens:     stats.Tokens,
+			Dropped:    stats.Dropped,
+			Duplicates: stats.Duplicates,
+			Model:      stats.Model,
+			DryRun:     run,
+		}, nil
+	}
+
+	spanCtx, span := startSpan(ctx, "github.create_review", attribute.Int("nit.comments", len(body.Comments)))
+	var review *github.PullRequestReview
+	err = retryRateLimit(func() (err error) {
+		review, _, err = gh.PullRequests.CreateReview(
+			spanCtx,
+			owner,
+			repository,
+			number,
+			body,
+		)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return &ReviewResponse{
+			Tokens:     stats.Tokens,
+			Dropped:    stats.Dropped,
+			Duplicates: stats.Duplicates,
+			Model:      stats.Model,
+		}, err
+	}
+	reviewComments.WithLabelValues(commentPosted).Add(float64(len(body.Comments)))
+	ai.Logger().Info("posted review", "stage", stageReview, "review_id", review.GetID(), "event", body.GetEvent(), "comments", len(body.Comments), "below_severity", stats.BelowSeverity, "dropped", stats.Dropped, "duplicates", stats.Duplicates, "tokens", stats.Tokens)
+
+	return &ReviewResponse{
+		Tokens:     stats.Tokens,
+		Id:         review.GetID(),
+		Dropped:    stats.Dropped,
+		Duplicates: stats.Duplicates,
+		Model:      stats.Model,
+		CommentIds: getReviewCommentIds(ctx, owner, repository, number, review.GetID(), config.MaxPages, gh),
+	}, nil
+}
+
+// Get the IDs of the comments posted with a review. This is best effort, the IDs that
+// were retrieved before an error are returned.
+func getReviewCommentIds(ctx context.Context, owner, repo string, number int, reviewID int64, maxPages int, gh *github.Client) []int64 {
+	comments, _ := paginate(maxPages, func(opts *github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+		return gh.PullRequests.ListReviewComments(ctx, owner, repo, number, reviewID, opts)
+	})
+
+	ids := []int64{}
+	for _, comment := range comments {
+		ids = append(ids, comment.GetID())
+	}
+	return ids
+}
+
+// Generate a review of the current changes in a pull request without posting it. Returns
+// the diff that was reviewed along with the review. The stats are also returned when
+// generating the review fails after spending tokens.
+func generateReview(ctx context.Context, event *github.PullRequestEvent, config *Config, ai *AI, gh *github.Client) (string, *github.PullRequestReviewRequest, *ReviewStats, error) {
 	var (
 		owner       = event.GetRepo().GetOwner().GetLogin()
 		repository  = event.GetRepo().GetName()
 		number      = event.GetPullRequest().GetNumber()
 		title       = event.GetPullRequest().GetTitle()
 		description = event.GetPullRequest().GetBody()
+		headSHA     = event.GetPullRequest().GetHead().GetSHA()
+		baseSHA     = event.GetPullRequest().GetBase().GetSHA()
 	)
 
-	// TODO: handle rate limit errors
-	diff, _, err := gh.PullRequests.GetRaw(
-		context.Background(),
-		owner,
-		repository,
-		number,
-		github.RawOptions{Type: github.Diff},
-	)
+	spanCtx, span := startSpan(ctx, "github.get_diff")
+	var diff string
+	err := retryRateLimit(func() (err error) {
+		diff, _, err = gh.PullRequests.GetRaw(
+			spanCtx,
+			owner,
+			repository,
+			number,
+			github.RawOptions{Type: github.Diff},
+		)
+		return err
+	})
+	endSpan(span, err)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
+	}
+
+	files := getChangedFileContents(ctx, owner, repository, headSHA, diff, gh)
+	codeContext := getCodeContext(ctx, owner, repository, baseSHA, diff, files, ai, gh)
+
+	details := &PullRequestDetails{
+		Number:           number,
+		Title:            title,
+		Description:      description,
+		Issues:           getLinkedIssues(ctx, owner, repository, description, gh),
+		Commits:          getCommitMessages(ctx, owner, repository, number, config.MaxPages, gh),
+		Files:            map[string]string{},
+		ExistingComments: getExistingReviewComments(ctx, owner, repository, number, config.MaxPages, gh),
+	}
+	for _, file := range files {
+		details.Files[file.Path] = strings.Join(file.Lines, "\n")
 	}
 
-	body, tokens, err := ai.GeneratePullRequestReview(number, title, description, diff)
+	body, stats, err := ai.GeneratePullRequestReview(ctx, details, diff, codeContext, config.ForRepo(owner, repository))
 	if err != nil {
-		return nil, err
+		return "", nil, stats, err
 	}
 
-	// TODO: handle rate limit errors
-	review, _, err := gh.PullRequests.CreateReview(
-		context.Background(),
-		owner,
-		repository,
-		number,
-		body,
-	)
+	return diff, body, stats, nil
+}
+
+// Gather the source code that helps ground a review of the diff: the head versions of the
+// changed files and the definitions and call sites of the symbols that the diff touches.
+// This is best effort, whatever can't be retrieved is left out.
+func getCodeContext(ctx context.Context, owner, repository, baseSHA, diff string, files []*fileContent, ai *AI, gh *github.Client) string {
+	sections := []string{}
+	if fileContext := formatFileContext(files, fileContextTokenBudget); fileContext != "" {
+		sections = append(sections, fileContext)
+	}
+
+	if baseSHA == "" {
+		return strings.Join(sections, "\n")
+	}
+	index, err := getSymbolIndex(ctx, owner, repository, baseSHA, gh)
 	if err != nil {
-		return nil, err
+		ai.Logger().Info("not adding symbol context", "stage", stageReview, "reason", err)
+		return strings.Join(sections, "\n")
 	}
 
-	return &ReviewResponse{
-		Tokens: tokens,
-		Id:     review.GetID(),
-	}, nil
+	changedPaths := map[string]bool{}
+	for _, file := range parseDiffFiles(diff) {
+		changedPaths[file.Path] = true
+	}
+	symbols := touchedSymbols(diff, index)
+	if symbolContext := formatSymbolContext(index, symbols, changedPaths, symbolContextTokenBudget); symbolContext != "" {
+		sections = append(sections, symbolContext)
+	}
+
+	return strings.Join(sections, "\n")
 }
@@ -1,6 +1,7 @@
 package nit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -114,8 +115,19 @@ func TestReviewPullRequest(t *testing.T) {
 		mockNotes         = "notes"
 		simpleMockDiff    = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3"
 		simpleMockPayload = "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 1, \"body\": \"Contructive comment...\"}]}"
+		mockFileContent   = "line 1\nnew line 2\nadd line 3\n"
+		mockCommitMessage = "Add line 3"
 	)
 
+	// The pull request details given to the AI when the commits are retrieved successfully
+	details := &PullRequestDetails{
+		Number:      number,
+		Title:       title,
+		Description: description,
+		Issues:      []*github.Issue{},
+		Commits:     []string{mockCommitMessage},
+	}
+
 	// A github event payload that should be reviewed
 	event := &github.PullRequestEvent{
 		Action: github.String("opened"),
@@ -132,6 +144,9 @@ func TestReviewPullRequest(t *testing.T) {
 			User: &github.User{
 				Login: github.String("user"),
 			},
+			Head: &github.PullRequestBranch{
+				SHA: github.String("abc123"),
+			},
 		},
 	}
 
@@ -169,7 +184,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(1),
 						},
 					},
@@ -185,7 +200,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(1),
 						},
 					},
@@ -201,7 +216,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(1),
 						},
 					},
@@ -217,7 +232,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(1),
 						},
 					},
@@ -226,24 +241,40 @@ func TestReviewPullRequest(t *testing.T) {
 			// simple diff with multiple valid comments
 			{
 				Diff:    simpleMockDiff,
-				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 1, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 3, \"body\": \"Contructive comment...\"}]}",
+				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 1, \"body\": \"Rename this variable.\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Handle the error here.\"},{\"path\": \"file.txt\", \"position\": 3, \"body\": \"Add a test for this case.\"}]}",
 				Want: &github.PullRequestReviewRequest{
 					Body:  github.String("bla bla bla pr body bla bla"),
 					Event: github.String("APPROVE"),
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nRename this variable."),
 							Position: github.Int(1),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nHandle the error here."),
 							Position: github.Int(2),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nAdd a test for this case."),
+							Position: github.Int(3),
+						},
+					},
+				},
+			},
+			// simple diff with a comment that suggests a change to a line
+			{
+				Diff:    simpleMockDiff,
+				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 3, \"body\": \"Contructive comment...\", \"suggestion\": {\"original\": \"new line 2\", \"replacement\": \"better line 2\"}}]}",
+				Want: &github.PullRequestReviewRequest{
+					Body:  github.String("bla bla bla pr body bla bla"),
+					Event: github.String("APPROVE"),
+					Comments: []*github.DraftReviewComment{
+						{
+							Path:     github.String("file.txt"),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment...\n\n```suggestion\nbetter line 2\n```"),
 							Position: github.Int(3),
 						},
 					},
@@ -269,7 +300,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(4),
 						},
 					},
@@ -285,7 +316,7 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nContructive comment..."),
 							Position: github.Int(3),
 						},
 					},
@@ -294,29 +325,29 @@ func TestReviewPullRequest(t *testing.T) {
 			// simple diff with multiple comments in the generated payload, one of which is invalid (invalid comment is in the middle of the comments array)
 			{
 				Diff:    simpleMockDiff,
-				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 1, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 5, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 3, \"body\": \"Contructive comment...\"}]}",
+				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 1, \"body\": \"Rename this variable.\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Handle the error here.\"},{\"path\": \"file.txt\", \"position\": 5, \"body\": \"Add a test for this case.\"},{\"path\": \"file.txt\", \"position\": 3, \"body\": \"Extract this into a function.\"}]}",
 				Want: &github.PullRequestReviewRequest{
 					Body:  github.String("bla bla bla pr body bla bla"),
 					Event: github.String("APPROVE"),
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nRename this variable."),
 							Position: github.Int(1),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nHandle the error here."),
 							Position: github.Int(2),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nAdd a test for this case."),
 							Position: github.Int(4),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nExtract this into a function."),
 							Position: github.Int(3),
 						},
 					},
@@ -324,7 +355,7 @@ func TestReviewPullRequest(t *testing.T) {
 			},
 			// simple diff with multiple comments in the generated payload and multiple invalid comments
 			{
-				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 15, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 6, \"body\": \"Contructive comment...\"},{\"path\": \"file.txt\", \"position\": 5, \"body\": \"Contructive comment...\"}]}",
+				Payload: "{\"body\": \"bla bla bla pr body bla bla\", \"event\": \"APPROVE\", \"comments\": [{\"path\": \"file.txt\", \"position\": 15, \"body\": \"Rename this variable.\"},{\"path\": \"file.txt\", \"position\": 2, \"body\": \"Handle the error here.\"},{\"path\": \"file.txt\", \"position\": 6, \"body\": \"Add a test for this case.\"},{\"path\": \"file.txt\", \"position\": 5, \"body\": \"Extract this into a function.\"}]}",
 				Diff:    simpleMockDiff,
 				Want: &github.PullRequestReviewRequest{
 					Body:  github.String("bla bla bla pr body bla bla"),
@@ -332,22 +363,22 @@ func TestReviewPullRequest(t *testing.T) {
 					Comments: []*github.DraftReviewComment{
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nRename this variable."),
 							Position: github.Int(4),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nHandle the error here."),
 							Position: github.Int(2),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nAdd a test for this case."),
 							Position: github.Int(4),
 						},
 						{
 							Path:     github.String("file.txt"),
-							Body:     github.String("Contructive comment..."),
+							Body:     github.String("<!-- nit:severity=minor -->\n**Minor**\n\nExtract this into a function."),
 							Position: github.Int(4),
 						},
 					},
@@ -362,6 +393,11 @@ func TestReviewPullRequest(t *testing.T) {
 					Completion: mockNotes,
 					Tokens:     10,
 				},
+				// return a mock response from checking the changes against the description
+				&CompletionResponse{
+					Completion: noissues,
+					Tokens:     5,
+				},
 				// return a mock response from generating the review body
 				&CompletionResponse{
 					Completion: c.Payload,
@@ -380,6 +416,21 @@ func TestReviewPullRequest(t *testing.T) {
 							w.Write([]byte(c.Diff))
 						}),
 					),
+					// return the commits in the pull request
+					ghMock.WithRequestMatch(
+						ghMock.GetReposPullsCommitsByOwnerByRepoByPullNumber,
+						[]*github.RepositoryCommit{
+							{Commit: &github.Commit{Message: github.String(mockCommitMessage)}},
+						},
+					),
+					// return the head version of the changed file
+					ghMock.WithRequestMatch(
+						ghMock.GetReposContentsByOwnerByRepoByPath,
+						github.RepositoryContent{
+							Type:    github.String("file"),
+							Content: github.String(mockFileContent),
+						},
+					),
 					// return a successful response from posting the pull request review
 					ghMock.WithRequestMatchHandler(
 						ghMock.PostReposPullsReviewsByOwnerByRepoByPullNumber,
@@ -392,7 +443,7 @@ func TestReviewPullRequest(t *testing.T) {
 			)
 
 			// should return no errors
-			_, ok := ReviewPullRequest(event, mockAI, mockGithub)
+			_, ok := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
 			assert.Nil(t, ok)
 
 			// assert that the payload "sent" to Github was formed properly
@@ -400,22 +451,81 @@ func TestReviewPullRequest(t *testing.T) {
 
 			// assert that the call to generate review notes is formed correctly
 			gotAI1 := mockProvider.calls.CreateCompletetion[0].Req
+			var files []*fileContent
+			for _, file := range parseDiffFiles(c.Diff) {
+				if !file.Deleted {
+					files = append(files, &fileContent{changedFile: file, Lines: []string{"line 1", "new line 2", "add line 3"}})
+				}
+			}
 			wantAI1 := &CompletionRequest{
-				Prompt: fmt.Sprintf(reviewCommentsPrompt, formatPullRequestDetails(number, title, description), mockAI.addPositionNumbersToDiff(c.Diff)),
+				Prompt: fmt.Sprintf(reviewCommentsPrompt, formatPullRequestDetails(details), formatCodeContext(formatFileContext(files, fileContextTokenBudget)), mockAI.addPositionNumbersToDiff(c.Diff)),
 				Model:  modelGood,
 				Format: formatText,
 			}
 			assert.Equal(t, wantAI1, gotAI1)
 
-			// assert that the call to generate review payload is formed correctly
+			// assert that the call to check the changes against the description is formed correctly
 			gotAI2 := mockProvider.calls.CreateCompletetion[1].Req
 			wantAI2 := &CompletionRequest{
-				Prompt: fmt.Sprintf(reviewPostBodyPrompt, formatPullRequestDetails(number, title, description), mockNotes),
+				Prompt: fmt.Sprintf(conformancePrompt, formatPullRequestDetails(details), c.Diff),
+				Model:  modelGood,
+				Format: formatText,
+			}
+			assert.Equal(t, wantAI2, gotAI2)
+
+			// assert that the call to generate review payload is formed correctly
+			gotAI3 := mockProvider.calls.CreateCompletetion[2].Req
+			wantAI3 := &CompletionRequest{
+				Prompt: fmt.Sprintf(reviewPostBodyPrompt, formatPullRequestDetails(details), mockNotes),
 				Model:  modelCheap,
 				Format: formatJSON,
 			}
-			assert.Equal(t, wantAI2, gotAI2)
+			assert.Equal(t, wantAI3, gotAI3)
+		}
+	})
+
+	t.Run("should add mismatches between the description and the changes to the review body", func(t *testing.T) {
+		report := "- Described but not implemented: the description says line 3 is removed"
+		responses := []string{mockNotes, report, simpleMockPayload}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				r := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: r, Tokens: 10}, nil
+			},
 		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		var reviewPayload *github.PullRequestReviewRequest
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(simpleMockDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsReviewsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					json.NewDecoder(r.Body).Decode(&reviewPayload)
+					w.Write([]byte(`{"id": 7}`))
+				}),
+			),
+			// return the comments posted with the review
+			ghMock.WithRequestMatch(
+				ghMock.GetReposPullsReviewsCommentsByOwnerByRepoByPullNumberByReviewId,
+				[]*github.PullRequestComment{{ID: github.Int64(8)}},
+			),
+		))
+
+		res, err := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
+		assert.Nil(t, err)
+		assert.Equal(t, 30, res.Tokens)
+		assert.Equal(t, int64(7), res.Id)
+		assert.Equal(t, []int64{8}, res.CommentIds)
+
+		want := fmt.Sprintf(conformanceSection, "bla bla bla pr body bla bla", report)
+		assert.Equal(t, want, reviewPayload.GetBody())
 	})
 
 	t.Run("should return AI provider errors when generating review notes", func(t *testing.T) {
@@ -427,7 +537,7 @@ func TestReviewPullRequest(t *testing.T) {
 		}
 		mockAI := NewAI(&mockProvider, &mockProvider)
 
-		_, ok := ReviewPullRequest(event, mockAI, mockGithub)
+		_, ok := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
 
@@ -448,7 +558,7 @@ func TestReviewPullRequest(t *testing.T) {
 		}
 		mockAI := NewAI(&mockProvider, &mockProvider)
 
-		_, ok := ReviewPullRequest(event, mockAI, mockGithub)
+		_, ok := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
 
@@ -477,7 +587,7 @@ func TestReviewPullRequest(t *testing.T) {
 		}
 		mockAI := NewAI(&mockProvider, &mockProvider)
 
-		_, ok := ReviewPullRequest(event, mockAI, mockGithub)
+		_, ok := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
 
@@ -514,9 +624,79 @@ func TestReviewPullRequest(t *testing.T) {
 		}
 		mockAI := NewAI(&mockProvider, &mockProvider)
 
-		_, ok := ReviewPullRequest(event, mockAI, mockGithub)
+		_, ok := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
 		assert.Error(t, ok)
 	})
+
+	t.Run("should return the tokens spent when posting the pull request review fails", func(t *testing.T) {
+		mockedHTTPClient := ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(simpleMockDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.PostReposPullsReviewsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ghMock.WriteError(w, http.StatusInternalServerError, "github went belly up or something")
+				}),
+			),
+		)
+		mockGithub := github.NewClient(mockedHTTPClient)
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: simpleMockPayload, Tokens: 10, Model: "gpt-4o"}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		res, err := ReviewPullRequest(context.Background(), event, &Config{}, mockAI, mockGithub)
+
+		assert.Error(t, err)
+		assert.Equal(t, &ReviewResponse{Tokens: 30, Model: "gpt-4o"}, res)
+	})
+
+	t.Run("should write the review instead of posting it in a dry run", func(t *testing.T) {
+		mockedHTTPClient := ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				// return the diff successfully
+				ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Write([]byte(simpleMockDiff))
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				// fail if the review is posted
+				ghMock.PostReposPullsReviewsByOwnerByRepoByPullNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Error("the review should not be posted")
+					ghMock.WriteError(w, http.StatusInternalServerError, "posted")
+				}),
+			),
+		)
+		mockGithub := github.NewClient(mockedHTTPClient)
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: simpleMockPayload, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+		writer := &dryRunWriterMock{}
+
+		config := &Config{
+			RepoConfig:   RepoConfig{DryRun: DryRunOn},
+			DryRunWriter: writer,
+		}
+		res, err := ReviewPullRequest(context.Background(), event, config, mockAI, mockGithub)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), res.Id)
+		assert.Len(t, writer.runs, 1)
+		assert.Equal(t, res.DryRun, writer.runs[0])
+		assert.Equal(t, "abc123", writer.runs[0].HeadSHA)
+		assert.Equal(t, "APPROVE", writer.runs[0].Review.GetEvent())
+		assert.Len(t, writer.runs[0].Review.Comments, 1)
+	})
 }
 
 func TestAddPositionNumbersToDiff(t *testing.T) {
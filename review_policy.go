@@ -0,0 +1,68 @@
+package nit
+
+import (
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// The events a review can be posted with.
+const (
+	eventApprove        = "APPROVE"
+	eventComment        = "COMMENT"
+	eventRequestChanges = "REQUEST_CHANGES"
+)
+
+// Which events a review can be posted with.
+const (
+	// Reviews approve or comment as the model decides, changes are never requested
+	ReviewPolicyApprove = "approve"
+	// Reviews never approve, changes are requested when blocking issues are found
+	ReviewPolicyNeverApprove = "never-approve"
+	// Reviews only ever comment
+	ReviewPolicyComment = "comment"
+	// Reviews approve or comment as the model decides, changes are requested when blocking
+	// issues are found
+	ReviewPolicyRequestChanges = "request-changes"
+)
+
+// Set the event of the review based on the configured policy and whether any of the
+// comments are blocking issues. The model only decides between APPROVE and COMMENT, only
+// the policy can request changes. Returns the number of blocking issues.
+func applyReviewPolicy(payload *github.PullRequestReviewRequest, severities commentSeverities, config RepoConfig) int {
+	blockers := countBlockers(payload.Comments, severities)
+
+	event := strings.ToUpper(payload.GetEvent())
+	if event != eventApprove {
+		event = eventComment
+	}
+
+	switch config.ReviewPolicy {
+	case ReviewPolicyComment:
+		event = eventComment
+	case ReviewPolicyNeverApprove:
+		event = eventComment
+		if blockers > 0 {
+			event = eventRequestChanges
+		}
+	case ReviewPolicyRequestChanges:
+		if blockers > 0 {
+			event = eventRequestChanges
+		}
+	}
+
+	payload.Event = github.String(event)
+	return blockers
+}
+
+// Count the review comments that are blocking issues. Blockers always meet the inline
+// severity so they are never moved to the review body.
+func countBlockers(comments []*github.DraftReviewComment, severities commentSeverities) int {
+	count := 0
+	for _, comment := range comments {
+		if severities[comment].severity == SeverityBlocker {
+			count++
+		}
+	}
+	return count
+}
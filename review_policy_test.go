@@ -0,0 +1,47 @@
+package nit
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyReviewPolicy(t *testing.T) {
+	createPayload := func(event string, severities ...string) (*github.PullRequestReviewRequest, commentSeverities) {
+		payload := &github.PullRequestReviewRequest{Event: github.String(event)}
+		tagged := commentSeverities{}
+		for _, severity := range severities {
+			comment := &github.DraftReviewComment{Body: github.String("comment")}
+			payload.Comments = append(payload.Comments, comment)
+			tagged[comment] = commentSeverity{severity: severity}
+		}
+		return payload, tagged
+	}
+
+	tests := []struct {
+		policy     string
+		event      string
+		severities []string
+		want       string
+		blockers   int
+	}{
+		// the model decides by default and changes are never requested
+		{"", "APPROVE", []string{SeverityBlocker}, "APPROVE", 1},
+		{ReviewPolicyApprove, "REQUEST_CHANGES", nil, "COMMENT", 0},
+		{ReviewPolicyComment, "APPROVE", []string{SeverityBlocker}, "COMMENT", 1},
+		{ReviewPolicyNeverApprove, "APPROVE", []string{SeverityMinor}, "COMMENT", 0},
+		{ReviewPolicyNeverApprove, "APPROVE", []string{SeverityBlocker}, "REQUEST_CHANGES", 1},
+		{ReviewPolicyRequestChanges, "APPROVE", []string{SeverityMinor}, "APPROVE", 0},
+		{ReviewPolicyRequestChanges, "COMMENT", []string{SeverityMinor, SeverityBlocker, SeverityBlocker}, "REQUEST_CHANGES", 2},
+	}
+
+	for _, test := range tests {
+		payload, severities := createPayload(test.event, test.severities...)
+
+		blockers := applyReviewPolicy(payload, severities, RepoConfig{ReviewPolicy: test.policy})
+
+		assert.Equal(t, test.blockers, blockers)
+		assert.Equal(t, test.want, payload.GetEvent(), "policy %q with event %q", test.policy, test.event)
+	}
+}
@@ -0,0 +1,208 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// A thread of review comments on a pull request.
+type reviewThread struct {
+	// The GraphQL node ID of the thread
+	ID         string `json:"id"`
+	IsResolved bool   `json:"isResolved"`
+	// Whether the lines the thread is on have changed since it was started
+	IsOutdated bool   `json:"isOutdated"`
+	Path       string `json:"path"`
+	// The lines in the head version of the file the thread is on, 0 when it is outdated.
+	// StartLine is 0 for threads on a single line.
+	Line      int `json:"line"`
+	StartLine int `json:"startLine"`
+	Comments  struct {
+		Nodes []*reviewThreadComment `json:"nodes"`
+	} `json:"comments"`
+}
+
+// A comment in a review thread.
+type reviewThreadComment struct {
+	// The GraphQL node ID of the comment
+	ID string `json:"id"`
+	// The REST ID of the comment
+	DatabaseID int64  `json:"databaseId"`
+	Body       string `json:"body"`
+	DiffHunk   string `json:"diffHunk"`
+	Author     struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes {
+          id
+          isResolved
+          isOutdated
+          path
+          line
+          startLine
+          comments(first: 100) {
+            nodes { id databaseId body diffHunk author { login } }
+          }
+        }
+      }
+    }
+  }
+}`
+
+const reviewThreadQuery = `query($id: ID!) {
+  node(id: $id) {
+    ... on PullRequestReviewComment {
+      pullRequestReviewThread {
+        id
+        isResolved
+        isOutdated
+        path
+        line
+        startLine
+        comments(first: 100) {
+          nodes { id databaseId body diffHunk author { login } }
+        }
+      }
+    }
+  }
+}`
+
+const resolveReviewThreadMutation = `mutation($threadId: ID!) {
+  resolveReviewThread(ctx, input: {threadId: $threadId}) {
+    thread { id isResolved }
+  }
+}`
+
+// Get the review threads on a pull request with their comments, following the cursors of
+// the results up to maxPages pages (defaultMaxPages when it isn't positive).
+func getReviewThreads(ctx context.Context, owner, repo string, number, maxPages int, gh *github.Client) ([]*reviewThread, error) {
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	threads := []*reviewThread{}
+	var cursor *string
+	for page := 0; page < maxPages; page++ {
+		var result struct {
+			Repository struct {
+				PullRequest struct {
+					ReviewThreads struct {
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						Nodes []*reviewThread `json:"nodes"`
+					} `json:"reviewThreads"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+
+		err := queryGraphQL(ctx, gh, reviewThreadsQuery, map[string]interface{}{
+			"owner":  owner,
+			"repo":   repo,
+			"number": number,
+			"cursor": cursor,
+		}, &result)
+		if err != nil {
+			return threads, err
+		}
+
+		reviewThreads := result.Repository.PullRequest.ReviewThreads
+		threads = append(threads, reviewThreads.Nodes...)
+		if !reviewThreads.PageInfo.HasNextPage {
+			break
+		}
+		cursor = github.String(reviewThreads.PageInfo.EndCursor)
+	}
+	return threads, nil
+}
+
+// Get the review thread that a comment belongs to with all of its comments. The comment is
+// identified by its GraphQL node ID, or by its REST ID when the node ID isn't known.
+// Returns an error when the comment isn't in a thread.
+func getReviewThread(ctx context.Context, owner, repo string, nodeID string, id int64, gh *github.Client) (*reviewThread, error) {
+	if nodeID == "" {
+		var comment *github.PullRequestComment
+		err := retryRateLimit(func() (err error) {
+			comment, _, err = gh.PullRequests.GetComment(ctx, owner, repo, id)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		nodeID = comment.GetNodeID()
+	}
+
+	var result struct {
+		Node struct {
+			PullRequestReviewThread *reviewThread `json:"pullRequestReviewThread"`
+		} `json:"node"`
+	}
+	err := queryGraphQL(ctx, gh, reviewThreadQuery, map[string]interface{}{
+		"id": nodeID,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Node.PullRequestReviewThread == nil {
+		return nil, fmt.Errorf("could not find the review thread of comment %d", id)
+	}
+	return result.Node.PullRequestReviewThread, nil
+}
+
+// Mark a review thread as resolved.
+func resolveReviewThread(ctx context.Context, id string, gh *github.Client) error {
+	return queryGraphQL(ctx, gh, resolveReviewThreadMutation, map[string]interface{}{
+		"threadId": id,
+	}, &struct{}{})
+}
+
+// The first comment of the thread, the one that started it.
+func (t *reviewThread) first() *reviewThreadComment {
+	if len(t.Comments.Nodes) == 0 {
+		return nil
+	}
+	return t.Comments.Nodes[0]
+}
+
+// The latest comment of the thread.
+func (t *reviewThread) last() *reviewThreadComment {
+	if len(t.Comments.Nodes) == 0 {
+		return nil
+	}
+	return t.Comments.Nodes[len(t.Comments.Nodes)-1]
+}
+
+// The lines (inclusive) in the head version of the file that the thread is on, both are 0
+// when the thread is outdated.
+func (t *reviewThread) lines() (int, int) {
+	if t.StartLine != 0 {
+		return t.StartLine, t.Line
+	}
+	return t.Line, t.Line
+}
+
+// Convert the comments of the thread to REST comments so they can be formatted for prompts.
+func (t *reviewThread) pullRequestComments() []*github.PullRequestComment {
+	comments := []*github.PullRequestComment{}
+	for _, c := range t.Comments.Nodes {
+		comments = append(comments, &github.PullRequestComment{
+			ID:       github.Int64(c.DatabaseID),
+			Body:     github.String(c.Body),
+			DiffHunk: github.String(c.DiffHunk),
+			Path:     github.String(t.Path),
+			User:     &github.User{Login: github.String(c.Author.Login)},
+		})
+	}
+	return comments
+}
@@ -0,0 +1,60 @@
+package nit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReviewThread(t *testing.T) {
+	mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatchHandler(
+			ghMock.EndpointPattern{Pattern: "/graphql", Method: "POST"},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req graphQLRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				// the thread is looked up from the comment, not by listing every thread
+				assert.Contains(t, req.Query, "node(id: $id)")
+				if req.Variables["id"] != "C3" {
+					w.Write([]byte(`{"data": {"node": {}}}`))
+					return
+				}
+				w.Write([]byte(`{"data": {"node": {"pullRequestReviewThread":
+					{"id": "T2", "path": "a.go", "isResolved": true, "isOutdated": true, "comments": {"nodes": [{"id": "C2", "databaseId": 2, "body": "second"}, {"id": "C3", "databaseId": 3, "body": "reply"}]}}
+				}}}`))
+			}),
+		),
+		ghMock.WithRequestMatch(
+			ghMock.GetReposPullsCommentsByOwnerByRepoByCommentId,
+			github.PullRequestComment{ID: github.Int64(3), NodeID: github.String("C3")},
+		),
+	))
+
+	t.Run("should find the thread of a comment by its node ID", func(t *testing.T) {
+		thread, err := getReviewThread(context.Background(), "owner", "repo", "C3", 0, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "T2", thread.ID)
+		assert.True(t, thread.IsResolved)
+		assert.True(t, thread.IsOutdated)
+		assert.Len(t, thread.Comments.Nodes, 2)
+	})
+
+	t.Run("should find the thread of a comment by its REST ID without a node ID", func(t *testing.T) {
+		thread, err := getReviewThread(context.Background(), "owner", "repo", "", 3, mockGithub)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "T2", thread.ID)
+	})
+
+	t.Run("should return an error when the comment isn't in a thread", func(t *testing.T) {
+		_, err := getReviewThread(context.Background(), "owner", "repo", "C4", 4, mockGithub)
+
+		assert.Error(t, err)
+	})
+}
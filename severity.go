@@ -0,0 +1,152 @@
+package nit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// How important a review comment is, from least to most.
+const (
+	SeverityNit     = "nit"
+	SeverityMinor   = "minor"
+	SeverityMajor   = "major"
+	SeverityBlocker = "blocker"
+)
+
+// Hidden at the start of inline comments so that the severity of posted comments (such as
+// the blockers of a stale review) can be read back without relying on the badge.
+const severityMarker = "<!-- nit:severity=%s -->"
+
+var (
+	severities          = []string{SeverityNit, SeverityMinor, SeverityMajor, SeverityBlocker}
+	categories          = []string{"bug", "security", "style", "perf", "test"}
+	severityMarkerRegex = regexp.MustCompile(`^<!-- nit:severity=(\w+) -->`)
+	// The marker and badge at the start of a posted comment
+	badgeRegex = regexp.MustCompile(`^<!-- nit:severity=\w+ -->\n[^\n]*\n\n`)
+)
+
+// The severity and category of an inline review comment.
+type commentSeverity struct {
+	severity string
+	category string
+}
+
+// The severity of each inline review comment of a generated review.
+type commentSeverities map[*github.DraftReviewComment]commentSeverity
+
+// Decide where each review comment goes based on its severity and the configured
+// thresholds. Comments at or above the inline severity stay in the review as inline
+// comments, comments at or above the summary severity are moved to a list in the review
+// body and the rest are dropped. Returns the severity of each inline comment, to badge
+// them with addBadges, and the number of comments removed from the inline comments.
+func applySeverities(payload *github.PullRequestReviewRequest, generated []*generatedComment, config RepoConfig) (commentSeverities, int) {
+	inline := severityRank(config.InlineSeverity, SeverityNit)
+	summary := severityRank(config.SummarySeverity, SeverityNit)
+
+	tagged := commentSeverities{}
+	kept := []*github.DraftReviewComment{}
+	summarized := []string{}
+	for i, comment := range payload.Comments {
+		severity, category := SeverityMinor, ""
+		if i < len(generated) && generated[i] != nil {
+			severity = normalizeSeverity(generated[i].Severity)
+			category = normalizeCategory(generated[i].Category)
+		}
+
+		rank := severityRank(severity, SeverityMinor)
+		switch {
+		case rank >= inline:
+			tagged[comment] = commentSeverity{severity: severity, category: category}
+			kept = append(kept, comment)
+		case rank >= summary:
+			body := strings.TrimSpace(suggestionBlockRegex.ReplaceAllString(comment.GetBody(), ""))
+			summarized = append(summarized, fmt.Sprintf("- %s `%s`: %s", formatBadge(severity, category), comment.GetPath(), body))
+		}
+	}
+
+	removed := len(payload.Comments) - len(kept)
+	payload.Comments = kept
+	if len(summarized) > 0 {
+		payload.Body = github.String(fmt.Sprintf(otherNotesSection, payload.GetBody(), strings.Join(summarized, "\n")))
+	}
+
+	return tagged, removed
+}
+
+// Add the severity marker and badge to the start of each inline review comment. This is
+// done last so that the comments are compared and critiqued without them.
+func addBadges(payload *github.PullRequestReviewRequest, severities commentSeverities) {
+	for _, comment := range payload.Comments {
+		tag, ok := severities[comment]
+		if !ok {
+			continue
+		}
+		comment.Body = github.String(fmt.Sprintf(severityMarker, tag.severity) + "\n" + formatBadge(tag.severity, tag.category) + "\n\n" + comment.GetBody())
+	}
+}
+
+// Get the rank of a severity (higher is more severe). Unknown severities have the rank
+// of the fallback.
+func severityRank(severity, fallback string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	for i, s := range severities {
+		if s == fallback {
+			return i
+		}
+	}
+	return 0
+}
+
+// Normalize the severity given by the model. Comments without a known severity are minor.
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	for _, s := range severities {
+		if s == severity {
+			return s
+		}
+	}
+	return SeverityMinor
+}
+
+// Normalize the category given by the model. Unknown categories are left out.
+func normalizeCategory(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	for _, c := range categories {
+		if c == category {
+			return c
+		}
+	}
+	return ""
+}
+
+// Build the badge shown at the top of a comment. Output format looks like:
+//
+//	**Major** · bug
+func formatBadge(severity, category string) string {
+	badge := fmt.Sprintf("**%s**", strings.ToUpper(severity[:1])+severity[1:])
+	if category != "" {
+		badge += " · " + category
+	}
+	return badge
+}
+
+// Get the severity of a posted review comment from its marker, empty when it has none.
+func parseSeverityMarker(body string) string {
+	match := severityMarkerRegex.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// Remove the severity marker and badge from the start of a posted review comment.
+func stripBadge(body string) string {
+	return badgeRegex.ReplaceAllString(body, "")
+}
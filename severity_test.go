@@ -0,0 +1,119 @@
+package nit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySeverities(t *testing.T) {
+	createPayload := func() (*github.PullRequestReviewRequest, []*generatedComment) {
+		payload := &github.PullRequestReviewRequest{
+			Body: github.String("summary"),
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("a.go"), Position: github.Int(1), Body: github.String("blocker comment")},
+				{Path: github.String("b.go"), Position: github.Int(2), Body: github.String("major comment")},
+				{Path: github.String("c.go"), Position: github.Int(3), Body: github.String("minor comment\n\n```suggestion\nfix\n```")},
+				{Path: github.String("d.go"), Position: github.Int(4), Body: github.String("nit comment")},
+			},
+		}
+		generated := []*generatedComment{
+			{Severity: "Blocker", Category: "security"},
+			{Severity: "major", Category: "bug"},
+			{Severity: "minor", Category: "unknown"},
+			{Severity: "nit", Category: "style"},
+		}
+		return payload, generated
+	}
+
+	t.Run("should post every comment inline by default", func(t *testing.T) {
+		payload, generated := createPayload()
+
+		tagged, removed := applySeverities(payload, generated, RepoConfig{})
+
+		assert.Equal(t, 0, removed)
+		assert.Equal(t, "summary", payload.GetBody())
+		assert.Equal(t, "blocker comment", payload.Comments[0].GetBody())
+		assert.Equal(t, commentSeverity{severity: SeverityBlocker, category: "security"}, tagged[payload.Comments[0]])
+		assert.Equal(t, commentSeverity{severity: SeverityNit, category: "style"}, tagged[payload.Comments[3]])
+	})
+
+	t.Run("should add badges with the severity of the comments", func(t *testing.T) {
+		payload, generated := createPayload()
+		tagged, _ := applySeverities(payload, generated, RepoConfig{})
+
+		addBadges(payload, tagged)
+
+		assert.Equal(t, "<!-- nit:severity=blocker -->\n**Blocker** · security\n\nblocker comment", payload.Comments[0].GetBody())
+		assert.Equal(t, "<!-- nit:severity=major -->\n**Major** · bug\n\nmajor comment", payload.Comments[1].GetBody())
+		assert.Equal(t, "<!-- nit:severity=minor -->\n**Minor**\n\nminor comment\n\n```suggestion\nfix\n```", payload.Comments[2].GetBody())
+		assert.Equal(t, "<!-- nit:severity=nit -->\n**Nit** · style\n\nnit comment", payload.Comments[3].GetBody())
+	})
+
+	t.Run("should summarize and drop comments below the thresholds", func(t *testing.T) {
+		payload, generated := createPayload()
+
+		tagged, removed := applySeverities(payload, generated, RepoConfig{InlineSeverity: SeverityMajor, SummarySeverity: SeverityMinor})
+
+		assert.Equal(t, 2, removed)
+		assert.Len(t, tagged, 2)
+		assert.Len(t, payload.Comments, 2)
+		assert.Equal(t, "a.go", payload.Comments[0].GetPath())
+		assert.Equal(t, "b.go", payload.Comments[1].GetPath())
+		assert.Equal(t, fmt.Sprintf(otherNotesSection, "summary", "- **Minor** `c.go`: minor comment"), payload.GetBody())
+	})
+
+	t.Run("should treat comments without a severity as minor", func(t *testing.T) {
+		payload, _ := createPayload()
+
+		_, removed := applySeverities(payload, nil, RepoConfig{InlineSeverity: SeverityMajor, SummarySeverity: SeverityMajor})
+
+		assert.Equal(t, 4, removed)
+		assert.Empty(t, payload.Comments)
+		assert.Equal(t, "summary", payload.GetBody())
+	})
+}
+
+func TestGeneratePullRequestReviewSeverities(t *testing.T) {
+	t.Run("should count the comments below the inline severity", func(t *testing.T) {
+		diff := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,3 @@\n line 1\n+line 2\n line 3\n"
+		payload := `{"body": "body", "event": "COMMENT", "comments": [` +
+			`{"path": "file.txt", "position": 2, "body": "Handle the error returned here", "severity": "major"},` +
+			`{"path": "file.txt", "position": 3, "body": "Rename this variable", "severity": "nit"}]}`
+		responses := []string{"notes", payload}
+		mockProvider := AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				resp := responses[0]
+				responses = responses[1:]
+				return &CompletionResponse{Completion: resp, Tokens: 10}, nil
+			},
+		}
+		mockAI := NewAI(&mockProvider, &mockProvider)
+
+		review, stats, err := mockAI.GeneratePullRequestReview(context.Background(), &PullRequestDetails{}, diff, "", RepoConfig{InlineSeverity: SeverityMajor})
+
+		assert.NoError(t, err)
+		assert.Len(t, review.Comments, 1)
+		assert.Equal(t, 1, stats.BelowSeverity)
+	})
+}
+
+func TestStripBadge(t *testing.T) {
+	t.Run("should remove the marker and badge of posted comments", func(t *testing.T) {
+		assert.Equal(t, "this breaks", stripBadge("<!-- nit:severity=blocker -->\n**Blocker** · bug\n\nthis breaks"))
+		assert.Equal(t, "**Blocker** this breaks", stripBadge("**Blocker** this breaks"))
+	})
+}
+
+func TestParseSeverityMarker(t *testing.T) {
+	t.Run("should read the severity of posted comments", func(t *testing.T) {
+		assert.Equal(t, SeverityBlocker, parseSeverityMarker("<!-- nit:severity=blocker -->\n**Blocker**\n\nthis breaks"))
+	})
+
+	t.Run("should not read the severity from the badge", func(t *testing.T) {
+		assert.Equal(t, "", parseSeverityMarker("**Blocker** this is written by hand"))
+	})
+}
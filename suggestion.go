@@ -0,0 +1,171 @@
+package nit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// A change to the lines a review comment is left on, posted as a GitHub suggested change
+// that authors can commit from the review.
+type suggestion struct {
+	// The current contents of the lines being replaced, used to check that the
+	// suggestion applies cleanly to the head version of the file
+	Original string `json:"original"`
+	// The code that replaces the lines
+	Replacement string `json:"replacement"`
+}
+
+// Add the valid suggested changes to the bodies of the review comments as ```suggestion
+// blocks. Suggestions that don't apply cleanly to the head version of the file are left
+// out, the comment is still posted without them. Comments that span multiple lines are
+// converted to line based comments since positions can only refer to a single line.
+func addSuggestionsToPayload(diff string, files map[string]string, payload *github.PullRequestReviewRequest, generated []*generatedComment) {
+	diffFiles := parseDiffFiles(diff)
+
+	for i, comment := range payload.Comments {
+		if i >= len(generated) || generated[i] == nil || generated[i].Suggestion == nil {
+			continue
+		}
+		if comment.Position == nil {
+			continue
+		}
+
+		file := findChangedFile(diffFiles, comment.GetPath())
+		if file == nil {
+			continue
+		}
+		content, ok := files[file.Path]
+		if !ok {
+			continue
+		}
+
+		start := comment.GetPosition()
+		if generated[i].StartPosition != nil {
+			start = *generated[i].StartPosition
+		}
+		startLine, endLine, ok := file.positionsToLines(start, comment.GetPosition())
+		if !ok {
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		if !suggestionApplies(lines, startLine, endLine, generated[i].Suggestion) {
+			continue
+		}
+
+		comment.Body = github.String(formatSuggestion(comment.GetBody(), generated[i].Suggestion))
+		if startLine != endLine {
+			comment.Position = nil
+			comment.StartLine = github.Int(startLine)
+			comment.Line = github.Int(endLine)
+			comment.StartSide = github.String("RIGHT")
+			comment.Side = github.String("RIGHT")
+		}
+	}
+}
+
+// Find the file in the diff with the given path (before or after the change).
+func findChangedFile(files []*changedFile, path string) *changedFile {
+	for _, file := range files {
+		if file.Path == path || file.OldPath == path {
+			return file
+		}
+	}
+	return nil
+}
+
+// Get the head version line numbers of the range of positions in the diff. Only ranges
+// of lines in the head version of the file within a single hunk can be suggested on.
+func (f *changedFile) positionsToLines(start, end int) (int, int, bool) {
+	if start < 1 || end < start || end > len(f.Positions) {
+		return 0, 0, false
+	}
+
+	first := f.Positions[start-1]
+	last := f.Positions[end-1]
+	if first.Line == 0 || last.Line == 0 || first.Hunk != last.Hunk {
+		return 0, 0, false
+	}
+
+	return first.Line, last.Line, true
+}
+
+// Check that a suggestion replaces exactly the given lines (inclusive) of a file and that
+// it actually changes something. Trailing whitespace is ignored.
+func suggestionApplies(lines []string, start, end int, s *suggestion) bool {
+	if start < 1 || end > len(lines) || start > end {
+		return false
+	}
+
+	original := normalizeLines(strings.Join(lines[start-1:end], "\n"))
+	return normalizeLines(s.Original) == original && normalizeLines(s.Replacement) != original
+}
+
+func normalizeLines(s string) string {
+	lines := strings.Split(strings.Trim(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Add a suggestion block to a review comment body. Output format looks like:
+//
+//	comment body
+//
+//	```suggestion
+//	replacement code
+//	```
+func formatSuggestion(body string, s *suggestion) string {
+	return fmt.Sprintf("%s\n\n```suggestion\n%s\n```", body, strings.Trim(s.Replacement, "\n"))
+}
+
+// The number of lines above and below the lines of a thread shown when replying to it.
+const commentCodeLines = 10
+
+var (
+	originalBlockRegex   = regexp.MustCompile("(?s)```original\n(.*?)\n?```")
+	suggestionValueRegex = regexp.MustCompile("(?s)```suggestion\n(.*?)\n?```")
+)
+
+// Build a prompt snippet for the lines (inclusive) of a file that a review thread is on,
+// along with the lines surrounding them. Nothing is added to the prompt when the lines
+// aren't in the file.
+func formatCommentedCode(path string, lines []string, start, end int) string {
+	if start < 1 || end < start || end > len(lines) {
+		return ""
+	}
+
+	file := &fileContent{
+		changedFile: &changedFile{Path: path, Changed: map[int]bool{}},
+		Lines:       lines,
+	}
+	for n := start; n <= end; n++ {
+		file.Changed[n] = true
+	}
+	ranges := expandRanges([]lineRange{{Start: start, End: end}}, commentCodeLines, len(lines))
+	return fmt.Sprintf(commentCodePrompt, formatFile(file, ranges))
+}
+
+// Replace the original and suggestion blocks at the end of a reply with a ```suggestion
+// block when the suggestion applies cleanly to the lines (inclusive) of the file that the
+// thread is on. Otherwise, both blocks are removed and only the text of the reply is kept.
+func applyReplySuggestion(reply string, lines []string, start, end int) string {
+	original := originalBlockRegex.FindStringSubmatch(reply)
+	replacement := suggestionValueRegex.FindStringSubmatch(reply)
+
+	body := originalBlockRegex.ReplaceAllString(reply, "")
+	body = strings.TrimSpace(suggestionBlockRegex.ReplaceAllString(body, ""))
+	if original == nil || replacement == nil {
+		return body
+	}
+
+	s := &suggestion{Original: original[1], Replacement: replacement[1]}
+	if !suggestionApplies(lines, start, end, s) {
+		return body
+	}
+	return formatSuggestion(body, s)
+}
@@ -0,0 +1,147 @@
+package nit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSuggestionsToPayload(t *testing.T) {
+	const (
+		diff    = "diff --git a/file.txt b/file.txt\nindex 123456789..123456789 100644\n--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,4 @@\nline 1\n-remove line 2\n+new line 2\n+add line 3\n line 4"
+		content = "line 1\nnew line 2\nadd line 3\nline 4\n"
+	)
+	files := map[string]string{"file.txt": content}
+
+	createPayload := func(position int) *github.PullRequestReviewRequest {
+		return &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				{
+					Path:     github.String("file.txt"),
+					Body:     github.String("comment"),
+					Position: github.Int(position),
+				},
+			},
+		}
+	}
+
+	t.Run("should add a suggestion block for a single line", func(t *testing.T) {
+		payload := createPayload(3)
+		generated := []*generatedComment{
+			{Suggestion: &suggestion{Original: "new line 2  ", Replacement: "better line 2"}},
+		}
+
+		addSuggestionsToPayload(diff, files, payload, generated)
+
+		assert.Equal(t, &github.DraftReviewComment{
+			Path:     github.String("file.txt"),
+			Body:     github.String("comment\n\n```suggestion\nbetter line 2\n```"),
+			Position: github.Int(3),
+		}, payload.Comments[0])
+	})
+
+	t.Run("should convert comments with suggestions on a range of lines to line comments", func(t *testing.T) {
+		payload := createPayload(4)
+		generated := []*generatedComment{
+			{
+				StartPosition: github.Int(3),
+				Suggestion:    &suggestion{Original: "new line 2\nadd line 3", Replacement: "combined line 2 and 3"},
+			},
+		}
+
+		addSuggestionsToPayload(diff, files, payload, generated)
+
+		assert.Equal(t, &github.DraftReviewComment{
+			Path:      github.String("file.txt"),
+			Body:      github.String("comment\n\n```suggestion\ncombined line 2 and 3\n```"),
+			StartLine: github.Int(2),
+			Line:      github.Int(3),
+			StartSide: github.String("RIGHT"),
+			Side:      github.String("RIGHT"),
+		}, payload.Comments[0])
+	})
+
+	t.Run("should leave out suggestions that don't apply cleanly", func(t *testing.T) {
+		tests := []struct {
+			position  int
+			generated *generatedComment
+		}{
+			// the original doesn't match the file
+			{3, &generatedComment{Suggestion: &suggestion{Original: "line 2", Replacement: "better line 2"}}},
+			// the suggestion doesn't change anything
+			{3, &generatedComment{Suggestion: &suggestion{Original: "new line 2", Replacement: "new line 2"}}},
+			// the comment is on a removed line
+			{2, &generatedComment{Suggestion: &suggestion{Original: "remove line 2", Replacement: "line 2"}}},
+			// the range includes a removed line
+			{3, &generatedComment{StartPosition: github.Int(2), Suggestion: &suggestion{Original: "new line 2", Replacement: "line 2"}}},
+			// the position is outside of the diff
+			{9, &generatedComment{Suggestion: &suggestion{Original: "line 4", Replacement: "line four"}}},
+		}
+
+		for _, test := range tests {
+			payload := createPayload(test.position)
+
+			addSuggestionsToPayload(diff, files, payload, []*generatedComment{test.generated})
+
+			assert.Equal(t, "comment", payload.Comments[0].GetBody())
+			assert.Equal(t, test.position, payload.Comments[0].GetPosition())
+		}
+	})
+
+	t.Run("should leave out suggestions for files without contents", func(t *testing.T) {
+		payload := createPayload(3)
+		generated := []*generatedComment{
+			{Suggestion: &suggestion{Original: "new line 2", Replacement: "better line 2"}},
+		}
+
+		addSuggestionsToPayload(diff, map[string]string{}, payload, generated)
+
+		assert.Equal(t, "comment", payload.Comments[0].GetBody())
+	})
+}
+
+func TestApplyReplySuggestion(t *testing.T) {
+	lines := []string{"line 1", "new line 2", "add line 3", "line 4"}
+
+	t.Run("should keep a suggestion that applies to the lines of the thread", func(t *testing.T) {
+		reply := "Return the error instead.\n\n```original\nnew line 2\nadd line 3\n```\n```suggestion\nbetter line 2\n```"
+
+		got := applyReplySuggestion(reply, lines, 2, 3)
+
+		assert.Equal(t, "Return the error instead.\n\n```suggestion\nbetter line 2\n```", got)
+	})
+
+	t.Run("should remove a suggestion that doesn't apply", func(t *testing.T) {
+		reply := "Return the error instead.\n\n```original\nline 4\n```\n```suggestion\nbetter line 4\n```"
+
+		got := applyReplySuggestion(reply, lines, 2, 3)
+
+		assert.Equal(t, "Return the error instead.", got)
+	})
+
+	t.Run("should remove a suggestion without the original lines", func(t *testing.T) {
+		reply := "Return the error instead.\n\n```suggestion\nbetter line 2\n```"
+
+		got := applyReplySuggestion(reply, nil, 0, 0)
+
+		assert.Equal(t, "Return the error instead.", got)
+	})
+
+	t.Run("should leave replies without suggestions as they are", func(t *testing.T) {
+		reply := "Use `errors.Is` here:\n\n```go\nerrors.Is(err, io.EOF)\n```"
+
+		got := applyReplySuggestion(reply, lines, 2, 2)
+
+		assert.Equal(t, reply, got)
+	})
+}
+
+func TestFormatCommentedCode(t *testing.T) {
+	lines := []string{"line 1", "line 2", "line 3"}
+
+	assert.Equal(t, fmt.Sprintf(commentCodePrompt, "File: file.txt\n    1  line 1\n>   2  line 2\n    3  line 3\n"), formatCommentedCode("file.txt", lines, 2, 2))
+	assert.Equal(t, "", formatCommentedCode("file.txt", lines, 3, 4))
+	assert.Equal(t, "", formatCommentedCode("file.txt", nil, 0, 0))
+}
@@ -0,0 +1,435 @@
+package nit
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	// The approximate number of tokens worth of definitions and call sites that will be
+	// added to the review prompt.
+	symbolContextTokenBudget = 6000
+	// The maximum number of symbols from the diff to look up in the index.
+	maxTouchedSymbols = 30
+	// The maximum number of call sites shown (and indexed) for each symbol.
+	maxCallSites = 5
+	// Definitions longer than this are truncated.
+	maxDefinitionLines = 40
+	// Files larger than this are not indexed, they are most likely generated.
+	maxIndexedFileSize = 512 * 1024
+	// Repository archives larger than this are not indexed. Archives that say how large
+	// they are up front are not downloaded, the download of any other is stopped here.
+	maxTarballSize = 100 * 1024 * 1024
+	// The number of symbol indexes (one per commit) kept in memory.
+	symbolIndexCacheSize = 16
+)
+
+var (
+	identifierRegex = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	callRegex       = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+	// Heuristics for finding definitions in languages other than Go. This covers the
+	// common function, class and type declarations of most popular languages.
+	definitionRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:(?:public|private|protected|internal|static|async|abstract|final|override|pub(?:\(crate\))?)\s+)*(?:def|class|function|func|fn|interface|type|struct|enum|trait|module|record)\s+([A-Za-z_][A-Za-z0-9_]*)`),
+		regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(?:async\s*)?(?:function\b|\([^)]*\)\s*=>|[A-Za-z_][A-Za-z0-9_]*\s*=>)`),
+	}
+	// Source files of these languages are indexed with the regex heuristics.
+	indexedExtensions = map[string]bool{
+		".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".rb": true,
+		".java": true, ".kt": true, ".rs": true, ".c": true, ".h": true, ".cpp": true,
+		".hpp": true, ".cs": true, ".php": true, ".swift": true, ".scala": true,
+	}
+	// Directories that contain third party or generated code.
+	ignoredDirectories = map[string]bool{
+		"vendor": true, "node_modules": true, "dist": true, "build": true, ".git": true,
+	}
+)
+
+// Returned when the repository archive is larger than maxTarballSize
+var errRepositoryTooLarge = errors.New("repository too large")
+
+// Indexed symbols are cached per commit since the contents of a commit never change.
+var symbolIndexes = newBoundedCache[string, *symbolIndex](symbolIndexCacheSize)
+
+// Where a symbol is defined or used in the repository.
+type symbolLocation struct {
+	Path string
+	Line int
+	// The source of the definition or the line of the call site
+	Code string
+}
+
+// A lightweight index of the definitions and call sites of the symbols in a repository.
+type symbolIndex struct {
+	Definitions map[string][]symbolLocation
+	CallSites   map[string][]symbolLocation
+}
+
+func newSymbolIndex() *symbolIndex {
+	return &symbolIndex{
+		Definitions: map[string][]symbolLocation{},
+		CallSites:   map[string][]symbolLocation{},
+	}
+}
+
+// Get the symbol index of the repository at the given commit. Indexes are built from the
+// repository tarball and cached.
+func getSymbolIndex(ctx context.Context, owner, repo, sha string, gh *github.Client) (*symbolIndex, error) {
+	key := fmt.Sprintf("%s/%s@%s", owner, repo, sha)
+	if index, ok := symbolIndexes.get(key); ok {
+		return index, nil
+	}
+
+	var link *url.URL
+	err := retryRateLimit(func() (err error) {
+		link, _, err = gh.Repositories.GetArchiveLink(
+			ctx,
+			owner,
+			repo,
+			github.Tarball,
+			&github.RepositoryContentGetOptions{Ref: sha},
+			1,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := gh.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download repository archive: %s", resp.Status)
+	}
+	if resp.ContentLength > maxTarballSize {
+		return nil, errRepositoryTooLarge
+	}
+
+	index, err := buildSymbolIndex(&sizeLimitedReader{r: resp.Body, n: maxTarballSize})
+	if err != nil {
+		return nil, err
+	}
+
+	symbolIndexes.add(key, index)
+	return index, nil
+}
+
+// Reads at most n bytes. Unlike io.LimitReader, which would cut the archive off and fail
+// with an unexpected EOF, reading past the limit fails with errRepositoryTooLarge.
+type sizeLimitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errRepositoryTooLarge
+	}
+	// Read one byte more than what is left to find out if there is more
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errRepositoryTooLarge
+	}
+	return n, err
+}
+
+// Build a symbol index from a gzipped repository tarball.
+func buildSymbolIndex(tarball io.Reader) (*symbolIndex, error) {
+	gz, err := gzip.NewReader(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	index := newSymbolIndex()
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > maxIndexedFileSize {
+			continue
+		}
+
+		// GitHub puts everything in a top level "owner-repo-sha" directory
+		_, name, found := strings.Cut(header.Name, "/")
+		if !found || isIgnoredPath(name) {
+			continue
+		}
+
+		ext := path.Ext(name)
+		if ext != ".go" && !indexedExtensions[ext] {
+			continue
+		}
+
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+		index.addFile(name, content)
+	}
+
+	return index, nil
+}
+
+func isIgnoredPath(name string) bool {
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if ignoredDirectories[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// Add the definitions and call sites in a file to the index. Go files are parsed properly,
+// everything else (and Go that doesn't parse) is indexed with regex heuristics.
+func (idx *symbolIndex) addFile(name string, content []byte) {
+	if path.Ext(name) == ".go" && idx.addGoFile(name, content) == nil {
+		return
+	}
+	idx.addOtherFile(name, content)
+}
+
+func (idx *symbolIndex) addGoFile(name string, content []byte) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, content, parser.SkipObjectResolution)
+	if err != nil {
+		return err
+	}
+
+	source := func(node ast.Node) string {
+		start := fset.Position(node.Pos()).Offset
+		end := fset.Position(node.End()).Offset
+		return truncateLines(string(content[start:end]), maxDefinitionLines)
+	}
+	line := func(pos token.Pos) int {
+		return fset.Position(pos).Line
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			idx.addDefinition(decl.Name.Name, symbolLocation{Path: name, Line: line(decl.Pos()), Code: source(decl)})
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					code := "type " + source(spec)
+					idx.addDefinition(spec.Name.Name, symbolLocation{Path: name, Line: line(spec.Pos()), Code: code})
+				case *ast.ValueSpec:
+					code := decl.Tok.String() + " " + source(spec)
+					for _, ident := range spec.Names {
+						idx.addDefinition(ident.Name, symbolLocation{Path: name, Line: line(spec.Pos()), Code: code})
+					}
+				}
+			}
+		}
+	}
+
+	lines := strings.Split(string(content), "\n")
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		var ident *ast.Ident
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			ident = fun
+		case *ast.SelectorExpr:
+			ident = fun.Sel
+		default:
+			return true
+		}
+
+		n := line(ident.Pos())
+		idx.addCallSite(ident.Name, symbolLocation{Path: name, Line: n, Code: strings.TrimSpace(lines[n-1])})
+		return true
+	})
+
+	return nil
+}
+
+func (idx *symbolIndex) addOtherFile(name string, content []byte) {
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxIndexedFileSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	for i, line := range lines {
+		if symbol := matchDefinition(line); symbol != "" {
+			idx.addDefinition(symbol, symbolLocation{Path: name, Line: i + 1, Code: definitionBlock(lines, i)})
+			continue
+		}
+		for _, match := range callRegex.FindAllStringSubmatch(line, -1) {
+			idx.addCallSite(match[1], symbolLocation{Path: name, Line: i + 1, Code: strings.TrimSpace(line)})
+		}
+	}
+}
+
+func matchDefinition(line string) string {
+	for _, re := range definitionRegexes {
+		if match := re.FindStringSubmatch(line); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// Guess the extent of the definition that starts at the given line. The definition is
+// assumed to end at the first blank line or the first line indented less than or equal
+// to the definition that isn't a closing bracket.
+func definitionBlock(lines []string, start int) string {
+	indent := func(line string) int {
+		return len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+
+	end := start + 1
+	for ; end < len(lines) && end-start < maxDefinitionLines; end++ {
+		line := lines[end]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			break
+		}
+		if indent(line) <= indent(lines[start]) {
+			if strings.HasPrefix(trimmed, "}") || strings.HasPrefix(trimmed, "end") {
+				end++
+			}
+			break
+		}
+	}
+
+	return strings.Join(lines[start:end], "\n")
+}
+
+func (idx *symbolIndex) addDefinition(symbol string, loc symbolLocation) {
+	idx.Definitions[symbol] = append(idx.Definitions[symbol], loc)
+}
+
+func (idx *symbolIndex) addCallSite(symbol string, loc symbolLocation) {
+	// Popular symbols can be called from thousands of places, only a handful are useful.
+	if len(idx.CallSites[symbol]) >= maxCallSites*4 {
+		return
+	}
+	idx.CallSites[symbol] = append(idx.CallSites[symbol], loc)
+}
+
+// Find the symbols with definitions in the index that are used on the lines added or
+// removed by the diff, in the order they first appear.
+func touchedSymbols(diff string, idx *symbolIndex) []string {
+	symbols := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		if !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		for _, ident := range identifierRegex.FindAllString(line[1:], -1) {
+			if seen[ident] || len(idx.Definitions[ident]) == 0 {
+				continue
+			}
+			seen[ident] = true
+			symbols = append(symbols, ident)
+			if len(symbols) == maxTouchedSymbols {
+				return symbols
+			}
+		}
+	}
+	return symbols
+}
+
+// Build a prompt snippet with the definitions and call sites of the symbols touched by the
+// diff. Locations in the changed files are left out since those files are already in the
+// prompt. Symbols are added until the token budget is spent. The output format looks like:
+//
+//	Symbol: name
+//	Defined in path/to/file.go:12
+//	func name() {...}
+//	Called from:
+//	path/to/other.go:34: name()
+func formatSymbolContext(idx *symbolIndex, symbols []string, changedPaths map[string]bool, budget int) string {
+	snippets := []string{}
+	remaining := budget
+	for _, symbol := range symbols {
+		var sb strings.Builder
+		for _, def := range idx.Definitions[symbol] {
+			if changedPaths[def.Path] {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("Defined in %s:%d\n%s\n", def.Path, def.Line, def.Code))
+		}
+
+		calls := []string{}
+		for _, call := range idx.CallSites[symbol] {
+			if changedPaths[call.Path] {
+				continue
+			}
+			calls = append(calls, fmt.Sprintf("%s:%d: %s\n", call.Path, call.Line, call.Code))
+			if len(calls) == maxCallSites {
+				break
+			}
+		}
+		if len(calls) > 0 {
+			sb.WriteString("Called from:\n" + strings.Join(calls, ""))
+		}
+
+		if sb.Len() == 0 {
+			continue
+		}
+		snippet := fmt.Sprintf("Symbol: %s\n%s", symbol, sb.String())
+		if tokens := estimateTokens(snippet); tokens <= remaining {
+			snippets = append(snippets, snippet)
+			remaining -= tokens
+		}
+	}
+
+	if len(snippets) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(symbolContextPrompt, strings.Join(snippets, "\n"))
+}
+
+func truncateLines(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "\n") + "\n..."
+}
@@ -0,0 +1,214 @@
+package nit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	mockGoFile = `package thing
+
+// Add two numbers
+func add(a, b int) int {
+	return a + b
+}
+
+type Thing struct {
+	Name string
+}
+
+func (t *Thing) Total() int {
+	return add(1, 2)
+}
+`
+	mockPythonFile = `import os
+
+def greet(name):
+    return "hello " + name
+
+class Greeter:
+    def run(self):
+        print(greet("world"))
+`
+)
+
+func createMockTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		err := archive.WriteHeader(&tar.Header{
+			Name:     "owner-repo-abc123/" + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		assert.Nil(t, err)
+		_, err = archive.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, archive.Close())
+	assert.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestBuildSymbolIndex(t *testing.T) {
+	tarball := createMockTarball(t, map[string]string{
+		"thing/thing.go":            mockGoFile,
+		"scripts/greet.py":          mockPythonFile,
+		"vendor/lib/lib.go":         "package lib\n\nfunc vendored() {}\n",
+		"README.md":                 "# Readme\n\nfunction notCode() {}\n",
+		"node_modules/pkg/index.js": "function ignored() {}\n",
+	})
+
+	index, err := buildSymbolIndex(bytes.NewReader(tarball))
+	assert.Nil(t, err)
+
+	t.Run("should index go definitions and call sites", func(t *testing.T) {
+		assert.Equal(t, []symbolLocation{{
+			Path: "thing/thing.go",
+			Line: 4,
+			Code: "func add(a, b int) int {\n\treturn a + b\n}",
+		}}, index.Definitions["add"])
+		assert.Equal(t, []symbolLocation{{
+			Path: "thing/thing.go",
+			Line: 8,
+			Code: "type Thing struct {\n\tName string\n}",
+		}}, index.Definitions["Thing"])
+		assert.Len(t, index.Definitions["Total"], 1)
+		assert.Equal(t, []symbolLocation{{
+			Path: "thing/thing.go",
+			Line: 13,
+			Code: "return add(1, 2)",
+		}}, index.CallSites["add"])
+	})
+
+	t.Run("should index other languages with heuristics", func(t *testing.T) {
+		assert.Equal(t, []symbolLocation{{
+			Path: "scripts/greet.py",
+			Line: 3,
+			Code: "def greet(name):\n    return \"hello \" + name",
+		}}, index.Definitions["greet"])
+		assert.Len(t, index.Definitions["Greeter"], 1)
+		assert.Equal(t, []symbolLocation{{
+			Path: "scripts/greet.py",
+			Line: 8,
+			Code: "print(greet(\"world\"))",
+		}}, index.CallSites["greet"])
+	})
+
+	t.Run("should not index vendored or unsupported files", func(t *testing.T) {
+		assert.Empty(t, index.Definitions["vendored"])
+		assert.Empty(t, index.Definitions["notCode"])
+		assert.Empty(t, index.Definitions["ignored"])
+	})
+}
+
+func TestFormatSymbolContext(t *testing.T) {
+	index := newSymbolIndex()
+	index.addFile("thing/thing.go", []byte(mockGoFile))
+	index.addFile("scripts/greet.py", []byte(mockPythonFile))
+
+	t.Run("should find the indexed symbols touched by the diff", func(t *testing.T) {
+		diff := "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1,2 +1,2 @@\n x := 1\n-y := add(x, 2)\n+y := add(x, 3) + Thing{}.Total()\n z := unknown()"
+
+		got := touchedSymbols(diff, index)
+
+		assert.Equal(t, []string{"add", "Thing", "Total"}, got)
+	})
+
+	t.Run("should format definitions and call sites outside of the changed files", func(t *testing.T) {
+		got := formatSymbolContext(index, []string{"greet"}, map[string]bool{}, 1000)
+
+		want := "Symbol: greet\nDefined in scripts/greet.py:3\ndef greet(name):\n    return \"hello \" + name\nCalled from:\nscripts/greet.py:8: print(greet(\"world\"))\n"
+		assert.Contains(t, got, want)
+	})
+
+	t.Run("should leave out locations in the changed files", func(t *testing.T) {
+		got := formatSymbolContext(index, []string{"add", "greet"}, map[string]bool{"thing/thing.go": true}, 1000)
+
+		assert.NotContains(t, got, "Symbol: add")
+		assert.Contains(t, got, "Symbol: greet")
+	})
+
+	t.Run("should return nothing when there are no symbols", func(t *testing.T) {
+		got := formatSymbolContext(index, []string{}, map[string]bool{}, 1000)
+
+		assert.Equal(t, "", got)
+	})
+}
+
+func TestGetSymbolIndex(t *testing.T) {
+	t.Run("should download, index and cache the repository archive", func(t *testing.T) {
+		tarball := createMockTarball(t, map[string]string{"thing/thing.go": mockGoFile})
+		downloads := 0
+
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposTarballByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Location", "https://codeload.github.com/owner/repo/legacy.tar.gz/abc123")
+					w.WriteHeader(http.StatusFound)
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/owner/repo/legacy.tar.gz/abc123", Method: "GET"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					downloads++
+					w.Write(tarball)
+				}),
+			),
+		))
+
+		index, err := getSymbolIndex(context.Background(), "owner", "repo", "abc123", mockGithub)
+		assert.Nil(t, err)
+		assert.Len(t, index.Definitions["add"], 1)
+
+		cached, err := getSymbolIndex(context.Background(), "owner", "repo", "abc123", mockGithub)
+		assert.Nil(t, err)
+		assert.Same(t, index, cached)
+		assert.Equal(t, 1, downloads)
+	})
+	t.Run("should not download archives that are too large", func(t *testing.T) {
+		mockGithub := github.NewClient(ghMock.NewMockedHTTPClient(
+			ghMock.WithRequestMatchHandler(
+				ghMock.GetReposTarballByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Location", "https://codeload.github.com/owner/repo/legacy.tar.gz/large")
+					w.WriteHeader(http.StatusFound)
+				}),
+			),
+			ghMock.WithRequestMatchHandler(
+				ghMock.EndpointPattern{Pattern: "/owner/repo/legacy.tar.gz/large", Method: "GET"},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Length", strconv.Itoa(maxTarballSize+1))
+					w.WriteHeader(http.StatusOK)
+				}),
+			),
+		))
+
+		index, err := getSymbolIndex(context.Background(), "owner", "repo", "large", mockGithub)
+		assert.ErrorIs(t, err, errRepositoryTooLarge)
+		assert.Nil(t, index)
+	})
+
+	t.Run("should stop downloading archives that turn out to be too large", func(t *testing.T) {
+		tarball := createMockTarball(t, map[string]string{"thing/thing.go": mockGoFile})
+
+		_, err := buildSymbolIndex(&sizeLimitedReader{r: bytes.NewReader(tarball), n: int64(len(tarball) / 2)})
+		assert.ErrorIs(t, err, errRepositoryTooLarge)
+
+		index, err := buildSymbolIndex(&sizeLimitedReader{r: bytes.NewReader(tarball), n: int64(len(tarball))})
+		assert.Nil(t, err)
+		assert.Len(t, index.Definitions["add"], 1)
+	})
+}
@@ -0,0 +1,28 @@
+package nit
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name spans are created under. Spans are sent to the global tracer provider, nothing
+// is recorded unless one is set with otel.SetTracerProvider.
+const tracerName = "github.com/evanmcneely/nit"
+
+// Start a span as a child of the span in the context. The span must be ended with endSpan.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End a span, marking it as failed when there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,59 @@
+package nit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	t.Run("should record completions as children of the span in the context", func(t *testing.T) {
+		mockProvider := &AIProviderMock{
+			CreateCompletetionFunc: func(req *CompletionRequest) (*CompletionResponse, error) {
+				return &CompletionResponse{Completion: "{}", Tokens: 11, Model: "test-model"}, nil
+			},
+		}
+		ctx, parent := provider.Tracer("test").Start(context.Background(), "webhook")
+		ai := NewAI(mockProvider, mockProvider)
+
+		_, err := ai.NewCompletion().Cheap().ReturnJSON().Create(ctx, "prompt")
+		assert.NoError(t, err)
+		parent.End()
+
+		spans := recorder.Ended()
+		completion := spans[len(spans)-2]
+		assert.Equal(t, "completion", completion.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), completion.Parent().SpanID())
+		assert.Contains(t, completion.Attributes(), attribute.String("nit.model", "test-model"))
+		assert.Contains(t, completion.Attributes(), attribute.Int("nit.tokens", 11))
+		assert.Contains(t, completion.Attributes(), attribute.String("nit.format", formatJSON))
+		assert.Contains(t, completion.Attributes(), attribute.String("nit.tier", "cheap"))
+	})
+
+	t.Run("should record fixing the payload", func(t *testing.T) {
+		body := &github.PullRequestReviewRequest{
+			Comments: []*github.DraftReviewComment{
+				{Path: github.String("missing.go"), Position: github.Int(1)},
+			},
+		}
+		(&AI{}).fixProblemsWithPayload(context.Background(), "diff --git a/file.go b/file.go\n@@ -1 +1 @@\n+line\n", body)
+
+		spans := recorder.Ended()
+		fix := spans[len(spans)-1]
+		assert.Equal(t, "fix_payload", fix.Name())
+		assert.Contains(t, fix.Attributes(), attribute.Int("nit.comments", 1))
+		assert.Contains(t, fix.Attributes(), attribute.Int("nit.comments_kept", 0))
+	})
+}